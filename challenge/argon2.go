@@ -0,0 +1,90 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/lib"
+	"github.com/ucaptcha/backend-go/types"
+	"golang.org/x/crypto/argon2"
+)
+
+// Defaults used for Argon2id parameters missing from the configuration.
+const (
+	defaultArgon2Memory     = 19 * 1024 // KiB
+	defaultArgon2Time       = 2
+	defaultArgon2Threads    = 1
+	defaultArgon2Difficulty = 8
+	argon2SaltLength        = 16
+	argon2KeyLength         = 32
+)
+
+// argon2Params returns the configured Argon2id cost parameters.
+func argon2Params() *types.Argon2Params {
+	cfg := config.GlobalConfig.Argon2
+	params := &types.Argon2Params{
+		Memory:  cfg.Memory,
+		Time:    cfg.Time,
+		Threads: cfg.Threads,
+		KeyLen:  argon2KeyLength,
+	}
+	if params.Memory == 0 {
+		params.Memory = defaultArgon2Memory
+	}
+	if params.Time == 0 {
+		params.Time = defaultArgon2Time
+	}
+	if params.Threads == 0 {
+		params.Threads = defaultArgon2Threads
+	}
+	return params
+}
+
+// newArgon2Challenge creates a puzzle asking for a nonce whose Argon2id
+// output under a random salt is below a target. The target is 2^(256 - T),
+// so on average 2^T hashes are needed to find a solution.
+func newArgon2Challenge(opts ChallengeOptions) (*types.Challenge, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	diff := config.GlobalConfig.Argon2.Difficulty
+	if diff <= 0 {
+		diff = defaultArgon2Difficulty
+	}
+	if opts.Difficulty != nil {
+		diff = *opts.Difficulty
+	}
+
+	return &types.Challenge{
+		ID:        lib.GenerateRandomID(),
+		Type:      types.PuzzleArgon2id,
+		Prefix:    salt,
+		Target:    new(big.Int).Lsh(big.NewInt(1), uint(argon2KeyLength*8-diff)),
+		Argon2:    argon2Params(),
+		T:         diff,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// VerifyArgon2 reports whether the Argon2id output for the nonce, read as a
+// big-endian integer, is below the target.
+func VerifyArgon2(params *types.Argon2Params, salt, nonce []byte, target *big.Int) bool {
+	key := argon2.IDKey(nonce, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return new(big.Int).SetBytes(key).Cmp(target) < 0
+}
+
+// verifyArgon2 verifies a nonce submitted for an Argon2id challenge.
+func (cm *ChallengeManager) verifyArgon2(ctx context.Context, challenge *types.Challenge, solution Solution) (VerifyResult, error) {
+	if solution.Nonce == "" || len(solution.Nonce) > maxNonceLength {
+		return ResultMalformed, fmt.Errorf("%w: nonce must be between 1 and %d bytes", ErrMalformed, maxNonceLength)
+	}
+	return cm.consume(ctx, challenge.ID, func() bool {
+		return VerifyArgon2(challenge.Argon2, challenge.Prefix, []byte(solution.Nonce), challenge.Target)
+	})
+}
@@ -1,9 +1,13 @@
 package challenge
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ucaptcha/backend-go/config"
@@ -18,10 +22,104 @@ var (
 	globalManagerOnce sync.Once
 )
 
+// Solution holds a client's answer to a challenge.
+type Solution struct {
+	Y           string   // Claimed output y = g^(2^T) mod N
+	ProofScheme string   // Proof scheme, inferred from the fields below when empty
+	Proof       string   // Wesolowski proof π
+	Midpoints   []string // Pietrzak proof midpoints μ_i
+	Nonce       string   // Nonce found for hashcash and Argon2id puzzles
+}
+
+// proof returns the proof scheme and the raw proof values of the solution.
+// An empty scheme means the solution carries no proof.
+func (s Solution) proof() (string, []string) {
+	scheme := s.ProofScheme
+	if scheme == "" {
+		switch {
+		case len(s.Midpoints) > 0:
+			scheme = ProofPietrzak
+		case s.Proof != "":
+			scheme = ProofWesolowski
+		}
+	}
+	if scheme == ProofPietrzak {
+		return scheme, s.Midpoints
+	}
+	if s.Proof != "" {
+		return scheme, []string{s.Proof}
+	}
+	return scheme, nil
+}
+
+// ChallengeOptions customizes a challenge created by NewChallengeWithOptions.
+type ChallengeOptions struct {
+	Difficulty *int64        // Defaults to the difficulty of the site or the configured difficulty of the puzzle type
+	Type       string        // Defaults to types.PuzzleVDF
+	Scheme     string        // Defaults to types.SchemeRSA, VDF puzzles only
+	Site       *storage.Site // Optional site the challenge is issued for, providing defaults
+	Hostname   string        // Optional hostname the challenge is issued for
+	Action     string        // Optional action the challenge protects, such as "login"
+}
+
+// Validate checks that the options describe a supported puzzle.
+func (o ChallengeOptions) Validate() error {
+	switch o.Type {
+	case "", types.PuzzleVDF:
+		switch o.Scheme {
+		case "", types.SchemeRSA:
+		case types.SchemeClassGroup:
+			if len(classGroupProofSchemes()) == 0 {
+				return fmt.Errorf("%s challenges require the %s proof scheme, which is not accepted", o.Scheme, ProofWesolowski)
+			}
+		default:
+			return fmt.Errorf("unsupported scheme: %s", o.Scheme)
+		}
+	case types.PuzzleHashcash, types.PuzzleArgon2id:
+		if o.Scheme != "" {
+			return fmt.Errorf("scheme is not applicable to %s puzzles", o.Type)
+		}
+		if o.Difficulty != nil && (*o.Difficulty < 1 || *o.Difficulty > maxHashPuzzleBits) {
+			return fmt.Errorf("difficulty of %s puzzles must be between 1 and %d bits", o.Type, maxHashPuzzleBits)
+		}
+	default:
+		return fmt.Errorf("unsupported puzzle type: %s", o.Type)
+	}
+	if !validLabel(o.Hostname) {
+		return fmt.Errorf("hostname must be at most %d letters, digits, '_', '-', '.' or '/'", maxLabelLength)
+	}
+	if !validLabel(o.Action) {
+		return fmt.Errorf("action must be at most %d letters, digits, '_', '-', '.' or '/'", maxLabelLength)
+	}
+	return nil
+}
+
+// maxLabelLength bounds the length of hostnames and actions.
+const maxLabelLength = 100
+
+// validLabel reports whether s can be used as a hostname or action. Both end
+// up in pass tokens, so they are restricted to a plain character set.
+func validLabel(s string) bool {
+	if len(s) > maxLabelLength {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // ChallengeManager handles the creation, retrieval, and verification of challenges.
 type ChallengeManager struct {
 	challengeStorage storage.ChallengeStorage
 	keyManager       *keys.KeyManager
+	difficulty       *DifficultyController // Optional, adjusts the default difficulty
+	defaultDiff      atomic.Int64          // Default difficulty set without a controller, 0 until set
 }
 
 // NewChallengeManager creates a new ChallengeManager instance.
@@ -41,27 +139,174 @@ func InitializeStorage(cs storage.ChallengeStorage, km *keys.KeyManager) {
 }
 
 // NewChallenge creates a new challenge using the global manager.
-func NewChallenge(difficulty ...int64) (*types.Challenge, error) {
+func NewChallenge(ctx context.Context, difficulty ...int64) (*types.Challenge, error) {
+	if globalManager == nil {
+		return nil, fmt.Errorf("challenge storage not initialized")
+	}
+	return globalManager.NewChallenge(ctx, difficulty...)
+}
+
+// NewChallengeWithOptions creates a new challenge using the global manager.
+func NewChallengeWithOptions(ctx context.Context, opts ChallengeOptions) (*types.Challenge, error) {
 	if globalManager == nil {
 		return nil, fmt.Errorf("challenge storage not initialized")
 	}
-	return globalManager.NewChallenge(difficulty...)
+	return globalManager.NewChallengeWithOptions(ctx, opts)
 }
 
 // VerifyChallenge verifies a challenge using the global manager.
-func VerifyChallenge(id string, yStr string) (int8, error) {
+func VerifyChallenge(ctx context.Context, id, siteKey string, solution Solution) (VerifyResult, error) {
+	if globalManager == nil {
+		return ResultUnavailable, fmt.Errorf("%w: not initialized", ErrUnavailable)
+	}
+	return globalManager.VerifyChallenge(ctx, id, siteKey, solution)
+}
+
+// SetDifficultyController makes the global manager take its default
+// difficulty from dc, and report issued and verified challenges to it.
+func SetDifficultyController(dc *DifficultyController) {
+	if globalManager != nil {
+		globalManager.SetDifficultyController(dc)
+	}
+}
+
+// SetDefaultDifficulty sets the default difficulty using the global manager.
+// Without one, there is nothing to set and the configured difficulty is
+// returned.
+func SetDefaultDifficulty(difficulty int64, reason string) int64 {
 	if globalManager == nil {
-		return 0, fmt.Errorf("challenge storage not initialized")
+		return config.GlobalConfig.Difficulty
+	}
+	return globalManager.SetDefaultDifficulty(difficulty, reason)
+}
+
+// Difficulty returns the default difficulty status using the global manager.
+func Difficulty() DifficultyStatus {
+	if globalManager == nil {
+		return DifficultyStatus{Difficulty: config.GlobalConfig.Difficulty}
+	}
+	return globalManager.Difficulty()
+}
+
+// SetDifficultyController makes the manager take its default difficulty
+// from dc, and report issued and verified challenges to it. It must be
+// called before the manager is used.
+func (cm *ChallengeManager) SetDifficultyController(dc *DifficultyController) {
+	cm.difficulty = dc
+}
+
+// DefaultDifficulty returns the difficulty of VDF challenges whose
+// difficulty is set neither by the request nor by the site.
+func (cm *ChallengeManager) DefaultDifficulty() int64 {
+	if cm.difficulty != nil {
+		return cm.difficulty.Difficulty()
+	}
+	if difficulty := cm.defaultDiff.Load(); difficulty > 0 {
+		return difficulty
+	}
+	return config.GlobalConfig.Difficulty
+}
+
+// SetDefaultDifficulty sets the default difficulty, logging it along with
+// the reason, and returns it. With adaptive difficulty, it is brought
+// within the controller bounds, and adjustments carry on from it.
+func (cm *ChallengeManager) SetDefaultDifficulty(difficulty int64, reason string) int64 {
+	if cm.difficulty != nil {
+		return cm.difficulty.SetDifficulty(difficulty, reason)
+	}
+	previous := cm.DefaultDifficulty()
+	cm.defaultDiff.Store(difficulty)
+	log.Printf("Default difficulty changed from %d to %d: %s", previous, difficulty, reason)
+	return difficulty
+}
+
+// Difficulty returns the default difficulty and, with adaptive difficulty,
+// the load it is adjusted to.
+func (cm *ChallengeManager) Difficulty() DifficultyStatus {
+	if cm.difficulty != nil {
+		return cm.difficulty.Status()
+	}
+	return DifficultyStatus{Difficulty: cm.DefaultDifficulty()}
+}
+
+// NewChallenge creates and stores a new RSA challenge.
+func (cm *ChallengeManager) NewChallenge(ctx context.Context, difficulty ...int64) (*types.Challenge, error) {
+	var opts ChallengeOptions
+	if len(difficulty) > 0 {
+		opts.Difficulty = &difficulty[0]
 	}
-	return globalManager.VerifyChallenge(id, yStr)
+	return cm.NewChallengeWithOptions(ctx, opts)
 }
 
-// NewChallenge creates and stores a new challenge.
-func (cm *ChallengeManager) NewChallenge(difficulty ...int64) (*types.Challenge, error) {
-	keyPair, err := cm.keyManager.GetRandomKey()
+// NewChallengeWithOptions creates and stores a new challenge.
+func (cm *ChallengeManager) NewChallengeWithOptions(ctx context.Context, opts ChallengeOptions) (*types.Challenge, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 
+	// Site defaults apply to VDF puzzles only, hash puzzles keep their own bit counts
+	site := opts.Site
+	if site != nil && site.Difficulty > 0 && opts.Difficulty == nil && (opts.Type == "" || opts.Type == types.PuzzleVDF) {
+		opts.Difficulty = &site.Difficulty
+	}
+
+	var challenge *types.Challenge
+	var err error
+	switch opts.Type {
+	case types.PuzzleHashcash:
+		challenge, err = newHashcashChallenge(opts)
+	case types.PuzzleArgon2id:
+		challenge, err = newArgon2Challenge(opts)
+	default:
+		challenge, err = cm.newVDFChallenge(ctx, opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting random key: %v", err)
+		return nil, err
+	}
+	ttl := TTL()
+	if site != nil {
+		challenge.Site = site.Key
+		if site.ChallengeTTL > 0 {
+			ttl = site.ChallengeTTL
+		}
+	}
+	challenge.ExpiresAt = challenge.CreatedAt.Add(ttl)
+	challenge.Hostname = opts.Hostname
+	challenge.Action = opts.Action
+
+	if err := cm.challengeStorage.Save(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("failed to save challenge: %w", err)
+	}
+	cm.difficulty.observeIssued()
+
+	return challenge, nil
+}
+
+// TTL returns how long challenges remain valid after being issued: the
+// configured challenge_ttl, or storage.ChallengeExpiry if none is set.
+func TTL() time.Duration {
+	if ttl := config.GlobalConfig.ChallengeTTL; ttl > 0 {
+		return ttl
+	}
+	return storage.ChallengeExpiry
+}
+
+// newVDFChallenge creates a VDF challenge on a random key of the requested scheme.
+func (cm *ChallengeManager) newVDFChallenge(ctx context.Context, opts ChallengeOptions) (*types.Challenge, error) {
+	scheme := opts.Scheme
+	if scheme == "" {
+		scheme = types.SchemeRSA
+	}
+
+	keyType := storage.KeyTypeRSA
+	if scheme == types.SchemeClassGroup {
+		keyType = storage.KeyTypeClassGroup
+	}
+
+	keyPair, err := cm.keyManager.GetRandomKey(ctx, keyType)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting random key: %w", err)
 	}
 	if keyPair == nil {
 		// This case should ideally not happen if GetRandomKey guarantees a key
@@ -69,34 +314,41 @@ func (cm *ChallengeManager) NewChallenge(difficulty ...int64) (*types.Challenge,
 	}
 
 	challengeID := lib.GenerateRandomID()
-	// N is still needed for generating g, which is part of the public challenge
-	g := lib.GenerateValidG(keyPair.Components.N)
 
 	// Set difficulty to the provided value or use the default
-	var diff int64 = config.GlobalConfig.Difficulty // Default difficulty
-	if len(difficulty) > 0 {
-		diff = difficulty[0]
+	diff := cm.DefaultDifficulty()
+	if opts.Difficulty != nil {
+		diff = *opts.Difficulty
 	}
 
 	challenge := &types.Challenge{
 		ID:        challengeID,
-		G:         g,
-		N:         keyPair.Components.N, // N is public
+		Type:      types.PuzzleVDF,
+		Scheme:    scheme,
 		T:         diff,
 		CreatedAt: time.Now(),
 		KeyID:     keyPair.ID, // Store KeyID instead of P, Q
 	}
 
-	if err := cm.challengeStorage.Save(challenge); err != nil {
-		return nil, fmt.Errorf("failed to save challenge: %v", err)
+	if scheme == types.SchemeClassGroup {
+		form, err := newClassGroupBase(keyPair.Discriminant)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate base form: %v", err)
+		}
+		challenge.Form = form
+		challenge.N = keyPair.Discriminant
+	} else {
+		// N is still needed for generating g, which is part of the public challenge
+		challenge.G = lib.GenerateValidG(keyPair.Components.N)
+		challenge.N = keyPair.Components.N // N is public
 	}
 
 	return challenge, nil
 }
 
 // GetChallenge retrieves a challenge by its ID.
-func (cm *ChallengeManager) GetChallenge(id string) (*types.Challenge, error) {
-	ch, err := cm.challengeStorage.Get(id)
+func (cm *ChallengeManager) GetChallenge(ctx context.Context, id string) (*types.Challenge, error) {
+	ch, err := cm.challengeStorage.Get(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get challenge %s: %v", id, err)
 	}
@@ -104,55 +356,145 @@ func (cm *ChallengeManager) GetChallenge(id string) (*types.Challenge, error) {
 }
 
 // VerifyChallenge verifies the provided solution against the stored challenge.
-func (cm *ChallengeManager) VerifyChallenge(id string, yStr string) (int8, error) {
-	challenge, err := cm.challengeStorage.Get(id)
-	if err != nil {
-		return 2, fmt.Errorf("could not found challenge: %s", id) // Challenge not found
+// Solutions carrying a proof are checked with the public modulus only;
+// otherwise the key's factorization is used to recompute y directly.
+// Malformed solutions leave the challenge in place; any other attempt
+// consumes it. Expired challenges are removed. Challenges issued for
+// another site than siteKey are reported as not found and left in place.
+//
+// Any result other than ResultValid and ResultInvalid comes with an error
+// wrapping the matching sentinel error, such as ErrNotFound. Internal
+// details, like storage errors and key IDs, are logged rather than returned.
+func (cm *ChallengeManager) VerifyChallenge(ctx context.Context, id, siteKey string, solution Solution) (VerifyResult, error) {
+	_, result, err := cm.verify(ctx, id, siteKey, solution)
+	return result, err
+}
+
+// verify looks up a challenge and checks the solution against it. The
+// challenge is returned along with the result when it was found.
+func (cm *ChallengeManager) verify(ctx context.Context, id, siteKey string, solution Solution) (*types.Challenge, VerifyResult, error) {
+	challenge, result, err := cm.lookupAndCheck(ctx, id, siteKey, solution)
+	cm.difficulty.observeResult(result)
+	return challenge, result, err
+}
+
+// lookupAndCheck implements verify.
+func (cm *ChallengeManager) lookupAndCheck(ctx context.Context, id, siteKey string, solution Solution) (*types.Challenge, VerifyResult, error) {
+	challenge, err := cm.challengeStorage.Get(ctx, id)
+	if errors.Is(err, storage.ErrTimeout) {
+		log.Printf("Failed to get challenge %s: %v", id, err)
+		return nil, ResultUnavailable, ErrUnavailable
+	} else if err != nil {
+		return nil, ResultNotFound, ErrNotFound
+	}
+	if challenge.Site != siteKey {
+		return nil, ResultNotFound, ErrNotFound
+	}
+	if challenge.Expired(time.Now()) {
+		if err := cm.challengeStorage.Delete(ctx, id); err != nil {
+			log.Printf("Failed to delete expired challenge %s: %v", id, err)
+		}
+		return challenge, ResultExpired, ErrExpired
 	}
 
-	// Retrieve the key used for this challenge
-	keyPair, err := cm.keyManager.GetKey(challenge.KeyID)
-	if err != nil {
-		return 4, fmt.Errorf("required key %s for challenge %s is missing, consider re-generating challenge", challenge.KeyID, id)
+	result, err := cm.check(ctx, challenge, solution)
+	return challenge, result, err
+}
+
+// check verifies a solution against a challenge that has not expired.
+func (cm *ChallengeManager) check(ctx context.Context, challenge *types.Challenge, solution Solution) (VerifyResult, error) {
+	id := challenge.ID
+	switch challenge.Type {
+	case types.PuzzleHashcash:
+		return cm.verifyHashcash(ctx, challenge, solution)
+	case types.PuzzleArgon2id:
+		return cm.verifyArgon2(ctx, challenge, solution)
+	}
+	if challenge.Scheme == types.SchemeClassGroup {
+		return cm.verifyClassGroup(ctx, challenge, solution)
 	}
 
-	y := new(big.Int)
-	y, ok := y.SetString(yStr, 10)
+	y, ok := new(big.Int).SetString(solution.Y, 10)
 	if !ok {
-		return 3, fmt.Errorf("invalid format for y: %s", yStr) // Invalid y format
+		return ResultMalformed, fmt.Errorf("%w: invalid format for y", ErrMalformed)
 	}
 
-	// Perform verification using the retrieved key components
-	pPrime := new(big.Int).Div(new(big.Int).Sub(keyPair.Components.P, big.NewInt(1)), big.NewInt(2))
-	qPrime := new(big.Int).Div(new(big.Int).Sub(keyPair.Components.Q, big.NewInt(1)), big.NewInt(2))
+	if scheme, rawProof := solution.proof(); scheme != "" {
+		verifier, ok := getProofVerifier(scheme)
+		if !ok {
+			return ResultMalformed, fmt.Errorf("%w: unsupported proof scheme: %s", ErrMalformed, scheme)
+		}
+		proof := make([]*big.Int, len(rawProof))
+		for i, raw := range rawProof {
+			if proof[i], ok = new(big.Int).SetString(raw, 10); !ok {
+				return ResultMalformed, fmt.Errorf("%w: invalid format for proof", ErrMalformed)
+			}
+		}
+		return cm.consume(ctx, id, func() bool {
+			return verifier(challenge.N, challenge.G, y, challenge.T, proof)
+		})
+	}
+
+	// Retrieve the key used for this challenge
+	keyPair, err := cm.keyManager.GetKey(ctx, challenge.KeyID)
+	if errors.Is(err, storage.ErrTimeout) {
+		log.Printf("Failed to get key %s: %v", challenge.KeyID, err)
+		return ResultUnavailable, ErrUnavailable
+	} else if err != nil {
+		log.Printf("Key %s of challenge %s is missing: %v", challenge.KeyID, id, err)
+		return ResultKeyUnavailable, ErrKeyUnavailable
+	}
+
+	vc := cm.keyManager.VerificationContext(keyPair)
+	return cm.consume(ctx, id, func() bool {
+		return verifyCRT(vc, challenge, y)
+	})
+}
 
-	// Computation of exponents modulo p' and q'
-	eP := new(big.Int).Exp(big.NewInt(2), big.NewInt(challenge.T), pPrime)
-	eQ := new(big.Int).Exp(big.NewInt(2), big.NewInt(challenge.T), qPrime)
+// verifyCRT checks y = g^(2^T) mod N using the factorization of N.
+func verifyCRT(vc *keys.VerificationContext, challenge *types.Challenge, y *big.Int) bool {
+	// Exponents modulo p' and q', cached per difficulty
+	eP, eQ := vc.Exponents(challenge.T)
 
 	// Decompose base and result to modulo p / modulo q
-	gP := new(big.Int).Mod(challenge.G, keyPair.Components.P)
-	gQ := new(big.Int).Mod(challenge.G, keyPair.Components.Q)
-	yP := new(big.Int).Exp(gP, eP, keyPair.Components.P)
-	yQ := new(big.Int).Exp(gQ, eQ, keyPair.Components.Q)
+	gP := new(big.Int).Mod(challenge.G, vc.P)
+	gQ := new(big.Int).Mod(challenge.G, vc.Q)
+	yP := new(big.Int).Exp(gP, eP, vc.P)
+	yQ := new(big.Int).Exp(gQ, eQ, vc.Q)
 
 	// Directly verify the modulus decomposition result
-	yp := new(big.Int).Mod(y, keyPair.Components.P)
-	yq := new(big.Int).Mod(y, keyPair.Components.Q)
-
-	if yp.Cmp(yP) == 0 && yq.Cmp(yQ) == 0 {
-		// Verification successful, delete the challenge
-		if delErr := cm.challengeStorage.Delete(id); delErr != nil {
-			// Log the deletion error but still return success for verification
-			fmt.Printf("Warning: Failed to delete challenge %s after successful verification: %v\n", id, delErr)
-		}
-		return 1, nil // Verification successful
+	yp := new(big.Int).Mod(y, vc.P)
+	yq := new(big.Int).Mod(y, vc.Q)
+
+	return yp.Cmp(yP) == 0 && yq.Cmp(yQ) == 0
+}
+
+// AcceptedProofSchemes returns the proof schemes accepted for a challenge.
+func AcceptedProofSchemes(ch *types.Challenge) []string {
+	switch {
+	case ch.Type == types.PuzzleHashcash, ch.Type == types.PuzzleArgon2id:
+		return nil
+	case ch.Scheme == types.SchemeClassGroup:
+		return classGroupProofSchemes()
+	default:
+		return ProofSchemes()
 	}
+}
 
-	// Verification failed, delete the challenge
-	if delErr := cm.challengeStorage.Delete(id); delErr != nil {
-		// Log the deletion error
-		fmt.Printf("Warning: Failed to delete challenge %s after failed verification: %v\n", id, delErr)
+// consume takes the challenge out of storage, then runs check and converts
+// its outcome into a result. Taking is atomic, so of concurrent
+// verifications of one challenge only one runs its check: a challenge is
+// single-use whatever the outcome and can succeed at most once. The others
+// find it already used, as it was present when they looked it up.
+func (cm *ChallengeManager) consume(ctx context.Context, id string, check func() bool) (VerifyResult, error) {
+	if _, err := cm.challengeStorage.Take(ctx, id); errors.Is(err, storage.ErrTimeout) {
+		log.Printf("Failed to take challenge %s: %v", id, err)
+		return ResultUnavailable, ErrUnavailable
+	} else if err != nil {
+		return ResultAlreadyUsed, ErrAlreadyUsed
+	}
+	if check() {
+		return ResultValid, nil
 	}
-	return 0, nil // Verification failed
+	return ResultInvalid, nil
 }
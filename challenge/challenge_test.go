@@ -0,0 +1,137 @@
+package challenge_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ucaptcha/backend-go/challenge"
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// solveHashcash finds a nonce solving a hashcash challenge.
+func solveHashcash(ch *types.Challenge) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if challenge.VerifyHashcash(ch.Prefix, []byte(nonce), ch.T) {
+			return nonce
+		}
+	}
+}
+
+// lookupBarrier holds back every Take until a number of Gets returned, so
+// that concurrent verifications all find the challenge before any of them
+// consumes it.
+type lookupBarrier struct {
+	storage.ChallengeStorage
+	lookups sync.WaitGroup
+}
+
+func (b *lookupBarrier) Get(ctx context.Context, id string) (*types.Challenge, error) {
+	defer b.lookups.Done()
+	return b.ChallengeStorage.Get(ctx, id)
+}
+
+func (b *lookupBarrier) Take(ctx context.Context, id string) (*types.Challenge, error) {
+	b.lookups.Wait()
+	return b.ChallengeStorage.Take(ctx, id)
+}
+
+// Of concurrent verifications of one challenge with the correct answer,
+// exactly one succeeds and the others find it already used.
+func TestVerifyChallengeConcurrent(t *testing.T) {
+	const verifiers = 32
+
+	memory := storage.NewMemoryChallengeStorage(0)
+	t.Cleanup(func() { memory.Close() })
+	cs := &lookupBarrier{ChallengeStorage: memory}
+	cs.lookups.Add(verifiers)
+	cm := challenge.NewChallengeManager(cs, nil)
+
+	difficulty := int64(8)
+	ch, err := cm.NewChallengeWithOptions(t.Context(), challenge.ChallengeOptions{
+		Type:       types.PuzzleHashcash,
+		Difficulty: &difficulty,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	solution := challenge.Solution{Nonce: solveHashcash(ch)}
+
+	results := make([]challenge.VerifyResult, verifiers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range verifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results[i], _ = cm.VerifyChallenge(t.Context(), ch.ID, "", solution)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	counts := make(map[challenge.VerifyResult]int)
+	for _, result := range results {
+		counts[result]++
+	}
+	if counts[challenge.ResultValid] != 1 || counts[challenge.ResultAlreadyUsed] != verifiers-1 {
+		t.Fatalf("results = %v, want 1 valid and %d already used", counts, verifiers-1)
+	}
+}
+
+// The default difficulty can be changed while challenges read it.
+func TestSetDefaultDifficultyConcurrent(t *testing.T) {
+	cs := storage.NewMemoryChallengeStorage(0)
+	t.Cleanup(func() { cs.Close() })
+	cm := challenge.NewChallengeManager(cs, nil)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cm.SetDefaultDifficulty(int64(1000+i), "test")
+		}()
+		go func() {
+			defer wg.Done()
+			cm.DefaultDifficulty()
+		}()
+	}
+	wg.Wait()
+
+	if got := cm.SetDefaultDifficulty(5000, "test"); got != 5000 || cm.DefaultDifficulty() != 5000 {
+		t.Fatalf("default difficulty = %d, want 5000", cm.DefaultDifficulty())
+	}
+}
+
+// Class group challenges are only issued while Wesolowski proofs, the only
+// ones they can be proven with, are accepted.
+func TestClassGroupProofSchemes(t *testing.T) {
+	saved := config.GlobalConfig.ProofSchemes
+	t.Cleanup(func() { config.GlobalConfig.ProofSchemes = saved })
+	ch := &types.Challenge{Type: types.PuzzleVDF, Scheme: types.SchemeClassGroup}
+	opts := challenge.ChallengeOptions{Scheme: types.SchemeClassGroup}
+
+	for _, tt := range []struct {
+		configured []string
+		want       []string
+	}{
+		{configured: nil, want: []string{challenge.ProofWesolowski}},
+		{configured: []string{challenge.ProofPietrzak, challenge.ProofWesolowski}, want: []string{challenge.ProofWesolowski}},
+		{configured: []string{challenge.ProofPietrzak}, want: nil},
+	} {
+		config.GlobalConfig.ProofSchemes = tt.configured
+		if got := challenge.AcceptedProofSchemes(ch); !slices.Equal(got, tt.want) {
+			t.Errorf("with proof_schemes %v, accepted schemes = %v, want %v", tt.configured, got, tt.want)
+		}
+		if err := opts.Validate(); (err == nil) != (len(tt.want) > 0) {
+			t.Errorf("with proof_schemes %v, Validate() = %v", tt.configured, err)
+		}
+	}
+}
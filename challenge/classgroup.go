@@ -0,0 +1,84 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ucaptcha/backend-go/classgroup"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// classGroupExponentBits is the size of the random exponent used to derive
+// a fresh base form for each class group challenge.
+const classGroupExponentBits = 128
+
+// newClassGroupBase derives a random base form for a challenge as a random
+// power of the generator of discriminant d.
+func newClassGroupBase(d *big.Int) (*classgroup.Form, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), classGroupExponentBits)
+	for {
+		r, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		if r.Sign() == 0 {
+			continue
+		}
+		return classgroup.Generator(d).Reduce().Pow(r)
+	}
+}
+
+// VerifyWesolowskiClassGroup checks a Wesolowski proof that y = x^(2^t) in the
+// class group. The Fiat-Shamir prime is derived as for RSA challenges, with
+// the discriminant encoded as a decimal string and the forms as their "a,b"
+// strings instead of big-endian integers.
+func VerifyWesolowskiClassGroup(x, y, pi *classgroup.Form, t int64) bool {
+	if t < 0 {
+		return false
+	}
+
+	l := hashToPrime(t, []byte(x.Discriminant().String()), []byte(x.String()), []byte(y.String()))
+	r := new(big.Int).Exp(big.NewInt(2), big.NewInt(t), l)
+
+	// y must equal pi^l * x^r
+	piL, err := pi.Pow(l)
+	if err != nil {
+		return false
+	}
+	xR, err := x.Pow(r)
+	if err != nil {
+		return false
+	}
+	lhs, err := classgroup.Compose(piL, xR)
+	if err != nil {
+		return false
+	}
+
+	return lhs.Equal(y)
+}
+
+// verifyClassGroup verifies a solution to a class group challenge. Nobody
+// holds a trapdoor for the class group, so the solution must carry a
+// Wesolowski proof.
+func (cm *ChallengeManager) verifyClassGroup(ctx context.Context, challenge *types.Challenge, solution Solution) (VerifyResult, error) {
+	scheme, rawProof := solution.proof()
+	if !slices.Contains(classGroupProofSchemes(), scheme) || len(rawProof) != 1 {
+		return ResultMalformed, fmt.Errorf("%w: class group challenges require a %s proof", ErrMalformed, ProofWesolowski)
+	}
+
+	y, err := classgroup.Parse(solution.Y, challenge.N)
+	if err != nil {
+		return ResultMalformed, fmt.Errorf("%w: invalid format for y", ErrMalformed)
+	}
+	pi, err := classgroup.Parse(rawProof[0], challenge.N)
+	if err != nil {
+		return ResultMalformed, fmt.Errorf("%w: invalid format for proof", ErrMalformed)
+	}
+
+	return cm.consume(ctx, challenge.ID, func() bool {
+		return VerifyWesolowskiClassGroup(challenge.Form, y, pi, challenge.T)
+	})
+}
@@ -0,0 +1,352 @@
+package challenge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// Defaults of the adaptive difficulty settings.
+const (
+	defaultDifficultyWindow   = 5 * time.Minute
+	defaultDifficultyInterval = 30 * time.Second
+	defaultDifficultyStep     = 1.5
+	defaultDifficultySustain  = 2
+	defaultDifficultyCooldown = 2 * time.Minute
+	defaultMinSamples         = 20
+)
+
+const (
+	windowBuckets        = 60 // Number of buckets a sliding window is split into
+	maxDifficultyHistory = 50 // Number of adjustments kept for the status
+)
+
+// DifficultyAdjustment is a change of the default difficulty.
+type DifficultyAdjustment struct {
+	Time   time.Time
+	From   int64
+	To     int64
+	Reason string
+}
+
+// DifficultyStatus describes the default difficulty and, when adaptive
+// difficulty is enabled, the load it is adjusted to.
+type DifficultyStatus struct {
+	Adaptive      bool
+	Difficulty    int64
+	MinDifficulty int64
+	MaxDifficulty int64
+	Window        time.Duration
+	Issued        int64                  // Challenges issued within the window
+	Solved        int64                  // Verifications that succeeded within the window
+	Failed        int64                  // Verifications that failed within the window
+	IssueRate     float64                // Challenges issued per second
+	VerifyRate    float64                // Verifications per second
+	FailureRatio  float64                // Share of verifications that failed
+	Adjustments   []DifficultyAdjustment // Most recent first
+}
+
+// DifficultyController adjusts the default difficulty of VDF challenges to
+// the load, measured over a sliding window: the rate challenges are issued
+// at, the rate answers are verified at and the share of answers that fail.
+//
+// The difficulty is raised by Step when any signal is above its high
+// threshold, and lowered by Step when all of them are below their low
+// thresholds. In between, it is left alone. A direction must hold for
+// Sustain evaluations in a row, and adjustments are at least Cooldown
+// apart, so that the difficulty does not flap on short bursts.
+//
+// Sites setting their own difficulty are not adjusted.
+type DifficultyController struct {
+	cfg   config.AdaptiveDifficultyConfig
+	stats *slidingWindow
+
+	mu           sync.Mutex
+	difficulty   int64
+	pending      int // Consecutive evaluations calling for a change, positive to raise
+	lastAdjusted time.Time
+	history      []DifficultyAdjustment
+}
+
+// NewDifficultyController creates a controller starting from difficulty,
+// brought within bounds. Unset settings take their defaults; the low
+// thresholds default to half the high ones.
+func NewDifficultyController(cfg config.AdaptiveDifficultyConfig, difficulty int64) (*DifficultyController, error) {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultDifficultyWindow
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultDifficultyInterval
+	}
+	if cfg.Step == 0 {
+		cfg.Step = defaultDifficultyStep
+	}
+	if cfg.Sustain <= 0 {
+		cfg.Sustain = defaultDifficultySustain
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = defaultDifficultyCooldown
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = defaultMinSamples
+	}
+	if cfg.IssueRateLow == 0 {
+		cfg.IssueRateLow = cfg.IssueRateHigh / 2
+	}
+	if cfg.VerifyRateLow == 0 {
+		cfg.VerifyRateLow = cfg.VerifyRateHigh / 2
+	}
+	if cfg.FailureRatioLow == 0 {
+		cfg.FailureRatioLow = cfg.FailureRatioHigh / 2
+	}
+
+	switch {
+	case cfg.MinDifficulty <= 0:
+		return nil, fmt.Errorf("min_difficulty must be positive")
+	case cfg.MaxDifficulty < cfg.MinDifficulty:
+		return nil, fmt.Errorf("max_difficulty must be at least min_difficulty")
+	case cfg.Step <= 1:
+		return nil, fmt.Errorf("step must be greater than 1")
+	case cfg.IssueRateHigh == 0 && cfg.VerifyRateHigh == 0 && cfg.FailureRatioHigh == 0:
+		return nil, fmt.Errorf("at least one of issue_rate_high, verify_rate_high and failure_ratio_high is required")
+	case cfg.IssueRateLow > cfg.IssueRateHigh, cfg.VerifyRateLow > cfg.VerifyRateHigh, cfg.FailureRatioLow > cfg.FailureRatioHigh:
+		return nil, fmt.Errorf("low thresholds must not exceed high thresholds")
+	}
+
+	return &DifficultyController{
+		cfg:        cfg,
+		stats:      newSlidingWindow(cfg.Window, time.Now()),
+		difficulty: min(max(difficulty, cfg.MinDifficulty), cfg.MaxDifficulty),
+	}, nil
+}
+
+// Run evaluates the load every interval until ctx is done.
+func (dc *DifficultyController) Run(ctx context.Context) {
+	ticker := time.NewTicker(dc.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			dc.evaluate(now)
+		}
+	}
+}
+
+// Difficulty returns the current default difficulty.
+func (dc *DifficultyController) Difficulty() int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.difficulty
+}
+
+// SetDifficulty sets the default difficulty by hand, within bounds, and
+// returns the difficulty set. The controller carries on from it.
+func (dc *DifficultyController) SetDifficulty(difficulty int64, reason string) int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	difficulty = min(max(difficulty, dc.cfg.MinDifficulty), dc.cfg.MaxDifficulty)
+	dc.adjust(time.Now(), difficulty, reason)
+	return difficulty
+}
+
+// Status returns the current difficulty, load and recent adjustments.
+func (dc *DifficultyController) Status() DifficultyStatus {
+	now := time.Now()
+	issued, solved, failed := dc.stats.totals(now)
+	issueRate, verifyRate, failureRatio := dc.rates(now, issued, solved, failed)
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	adjustments := make([]DifficultyAdjustment, len(dc.history))
+	for i, adjustment := range dc.history {
+		adjustments[len(dc.history)-1-i] = adjustment
+	}
+	return DifficultyStatus{
+		Adaptive:      true,
+		Difficulty:    dc.difficulty,
+		MinDifficulty: dc.cfg.MinDifficulty,
+		MaxDifficulty: dc.cfg.MaxDifficulty,
+		Window:        dc.cfg.Window,
+		Issued:        issued,
+		Solved:        solved,
+		Failed:        failed,
+		IssueRate:     issueRate,
+		VerifyRate:    verifyRate,
+		FailureRatio:  failureRatio,
+		Adjustments:   adjustments,
+	}
+}
+
+// observeIssued records an issued challenge. It does nothing on a nil controller.
+func (dc *DifficultyController) observeIssued() {
+	if dc != nil {
+		dc.stats.add(time.Now(), 1, 0, 0)
+	}
+}
+
+// observeResult records the outcome of a verification. Only answers to a
+// live challenge tell solvers from guessers: unknown, replayed and expired
+// challenges cost nothing to send and server-side failures say nothing about
+// the clients, so they are not counted. It does nothing on a nil controller.
+func (dc *DifficultyController) observeResult(result VerifyResult) {
+	if dc == nil {
+		return
+	}
+	switch result {
+	case ResultValid:
+		dc.stats.add(time.Now(), 0, 1, 0)
+	case ResultInvalid, ResultMalformed:
+		dc.stats.add(time.Now(), 0, 0, 1)
+	}
+}
+
+// rates derives the signals from the counts of the window. Until a whole
+// window was observed, rates are taken over the time observed.
+func (dc *DifficultyController) rates(now time.Time, issued, solved, failed int64) (issueRate, verifyRate, failureRatio float64) {
+	elapsed := min(now.Sub(dc.stats.start), dc.cfg.Window)
+	seconds := max(elapsed.Seconds(), dc.stats.width.Seconds())
+	verified := solved + failed
+	if verified > 0 {
+		failureRatio = float64(failed) / float64(verified)
+	}
+	return float64(issued) / seconds, float64(verified) / seconds, failureRatio
+}
+
+// evaluate compares the load to the thresholds and adjusts the difficulty
+// once a direction has held long enough. Nothing is adjusted before a
+// whole window was observed.
+func (dc *DifficultyController) evaluate(now time.Time) {
+	if now.Sub(dc.stats.start) < dc.cfg.Window {
+		return
+	}
+	issued, solved, failed := dc.stats.totals(now)
+	issueRate, verifyRate, failureRatio := dc.rates(now, issued, solved, failed)
+	// Too few answers make for a meaningless ratio
+	ratioKnown := solved+failed >= dc.cfg.MinSamples
+
+	var above []string
+	calm := true
+	check := func(name string, value, high, low float64, known bool) {
+		if high == 0 || !known {
+			return
+		}
+		if value > high {
+			above = append(above, fmt.Sprintf("%s %.2f above %.2f", name, value, high))
+		}
+		if value >= low {
+			calm = false
+		}
+	}
+	check("issue rate", issueRate, dc.cfg.IssueRateHigh, dc.cfg.IssueRateLow, true)
+	check("verify rate", verifyRate, dc.cfg.VerifyRateHigh, dc.cfg.VerifyRateLow, true)
+	check("failure ratio", failureRatio, dc.cfg.FailureRatioHigh, dc.cfg.FailureRatioLow, ratioKnown)
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	switch {
+	case len(above) > 0:
+		dc.pending = max(dc.pending, 0) + 1
+	case calm:
+		dc.pending = min(dc.pending, 0) - 1
+	default:
+		dc.pending = 0
+	}
+	if abs(dc.pending) < dc.cfg.Sustain || now.Sub(dc.lastAdjusted) < dc.cfg.Cooldown {
+		return
+	}
+
+	if dc.pending > 0 {
+		raised := min(int64(math.Ceil(float64(dc.difficulty)*dc.cfg.Step)), dc.cfg.MaxDifficulty)
+		if raised > dc.difficulty {
+			dc.adjust(now, raised, strings.Join(above, ", "))
+		}
+	} else {
+		lowered := max(int64(float64(dc.difficulty)/dc.cfg.Step), dc.cfg.MinDifficulty)
+		if lowered < dc.difficulty {
+			dc.adjust(now, lowered, fmt.Sprintf("load below low thresholds: issue rate %.2f, verify rate %.2f, failure ratio %.2f", issueRate, verifyRate, failureRatio))
+		}
+	}
+	dc.pending = 0
+}
+
+// adjust changes the difficulty, logs it and records it in the history.
+// The caller must hold dc.mu.
+func (dc *DifficultyController) adjust(now time.Time, difficulty int64, reason string) {
+	log.Printf("Default difficulty changed from %d to %d: %s", dc.difficulty, difficulty, reason)
+	dc.history = append(dc.history, DifficultyAdjustment{Time: now, From: dc.difficulty, To: difficulty, Reason: reason})
+	if len(dc.history) > maxDifficultyHistory {
+		dc.history = dc.history[len(dc.history)-maxDifficultyHistory:]
+	}
+	dc.difficulty = difficulty
+	dc.lastAdjusted = now
+	dc.pending = 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// windowBucket holds the counts of one slot of a sliding window.
+type windowBucket struct {
+	slot                   int64
+	issued, solved, failed int64
+}
+
+// slidingWindow counts events over the last window, in windowBuckets
+// buckets: counts leave the window one bucket at a time.
+type slidingWindow struct {
+	start time.Time
+	width time.Duration
+
+	mu      sync.Mutex
+	buckets [windowBuckets]windowBucket
+}
+
+func newSlidingWindow(window time.Duration, start time.Time) *slidingWindow {
+	return &slidingWindow{start: start, width: max(window/windowBuckets, time.Millisecond)}
+}
+
+// slot returns the index of the slot now falls in.
+func (w *slidingWindow) slot(now time.Time) int64 {
+	return int64(now.Sub(w.start) / w.width)
+}
+
+func (w *slidingWindow) add(now time.Time, issued, solved, failed int64) {
+	slot := w.slot(now)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[slot%windowBuckets]
+	if b.slot != slot {
+		*b = windowBucket{slot: slot}
+	}
+	b.issued += issued
+	b.solved += solved
+	b.failed += failed
+}
+
+// totals sums the counts of the buckets within the window.
+func (w *slidingWindow) totals(now time.Time) (issued, solved, failed int64) {
+	current := w.slot(now)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range w.buckets {
+		if current-b.slot < windowBuckets {
+			issued += b.issued
+			solved += b.solved
+			failed += b.failed
+		}
+	}
+	return issued, solved, failed
+}
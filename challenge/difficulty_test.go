@@ -0,0 +1,208 @@
+package challenge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// load is what happens between two evaluations of a controller.
+type load struct {
+	issued, solved, failed int64
+	want                   int64 // Difficulty after the evaluation
+}
+
+// Loads measured over a 60s window, against an issue rate band of 5 to 10
+// challenges per second.
+var (
+	high = load{issued: 1200} // 20 per second
+	band = load{issued: 450}  // 7.5 per second
+	calm = load{issued: 60}   // 1 per second
+)
+
+func (l load) then(want int64) load {
+	l.want = want
+	return l
+}
+
+func TestDifficultyControllerEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        func(*config.AdaptiveDifficultyConfig)
+		difficulty int64
+		loads      []load
+	}{
+		{
+			name:       "raise after sustain high evaluations",
+			difficulty: 100,
+			loads:      []load{high.then(100), high.then(200), high.then(200), high.then(400)},
+		},
+		{
+			name:       "interrupted high load does not raise",
+			difficulty: 100,
+			loads:      []load{high.then(100), band.then(100), high.then(100), calm.then(100)},
+		},
+		{
+			name:       "hold inside the band",
+			difficulty: 200,
+			loads:      []load{band.then(200), band.then(200), band.then(200), band.then(200)},
+		},
+		{
+			name:       "lower after sustain calm evaluations",
+			difficulty: 400,
+			loads:      []load{calm.then(400), calm.then(200)},
+		},
+		{
+			name:       "respect cooldown",
+			cfg:        func(cfg *config.AdaptiveDifficultyConfig) { cfg.Cooldown = 150 * time.Second },
+			difficulty: 100,
+			loads:      []load{high.then(100), high.then(200), high.then(200), high.then(200), high.then(400)},
+		},
+		{
+			name:       "stay within max",
+			difficulty: 800,
+			loads:      []load{high.then(800), high.then(1000), high.then(1000), high.then(1000)},
+		},
+		{
+			name:       "stay within min",
+			difficulty: 150,
+			loads:      []load{calm.then(150), calm.then(100), calm.then(100), calm.then(100)},
+		},
+		{
+			name:       "ignore failure ratio below min samples",
+			difficulty: 400,
+			loads:      []load{{issued: 60, failed: 19, want: 400}, {issued: 60, failed: 19, want: 200}},
+		},
+		{
+			name:       "raise on failure ratio from min samples",
+			difficulty: 100,
+			loads:      []load{{issued: 60, failed: 20, want: 100}, {issued: 60, failed: 20, want: 200}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.AdaptiveDifficultyConfig{
+				MinDifficulty:    100,
+				MaxDifficulty:    1000,
+				Window:           time.Minute,
+				Step:             2,
+				Sustain:          2,
+				Cooldown:         time.Nanosecond,
+				IssueRateHigh:    10,
+				FailureRatioHigh: 0.5,
+				MinSamples:       20,
+			}
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			dc, err := NewDifficultyController(cfg, tt.difficulty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			dc.stats = newSlidingWindow(cfg.Window, start)
+
+			// Evaluations a window apart only see the load added since the previous one
+			for i, l := range tt.loads {
+				now := start.Add(time.Duration(i+1) * cfg.Window)
+				dc.stats.add(now, l.issued, l.solved, l.failed)
+				dc.evaluate(now)
+				if got := dc.Difficulty(); got != l.want {
+					t.Fatalf("difficulty after evaluation %d = %d, want %d", i+1, got, l.want)
+				}
+			}
+		})
+	}
+}
+
+// Nothing is adjusted before a whole window was observed.
+func TestDifficultyControllerFirstWindow(t *testing.T) {
+	cfg := config.AdaptiveDifficultyConfig{MinDifficulty: 100, MaxDifficulty: 1000, Window: time.Minute, Sustain: 1, IssueRateHigh: 10}
+	dc, err := NewDifficultyController(cfg, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	dc.stats = newSlidingWindow(cfg.Window, start)
+	dc.stats.add(start, 10000, 0, 0)
+	dc.evaluate(start.Add(cfg.Window - time.Second))
+	if got := dc.Difficulty(); got != 100 {
+		t.Fatalf("difficulty within the first window = %d, want 100", got)
+	}
+}
+
+// Answers to unknown or used challenges cost nothing to send, so they must
+// not move the difficulty.
+func TestDifficultyControllerObserveResult(t *testing.T) {
+	tests := []struct {
+		result VerifyResult
+		want   int64
+	}{
+		{result: ResultNotFound, want: 100},
+		{result: ResultAlreadyUsed, want: 100},
+		{result: ResultExpired, want: 100},
+		{result: ResultInvalid, want: 200},
+		{result: ResultMalformed, want: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.result.String(), func(t *testing.T) {
+			cfg := config.AdaptiveDifficultyConfig{
+				MinDifficulty:    100,
+				MaxDifficulty:    1000,
+				Window:           time.Minute,
+				Step:             2,
+				Sustain:          1,
+				VerifyRateHigh:   10,
+				FailureRatioHigh: 0.5,
+				MinSamples:       20,
+			}
+			dc, err := NewDifficultyController(cfg, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dc.stats = newSlidingWindow(cfg.Window, time.Now().Add(-cfg.Window))
+
+			for range 1000 {
+				dc.observeResult(tt.result)
+			}
+			dc.evaluate(time.Now())
+			if got := dc.Difficulty(); got != tt.want {
+				t.Fatalf("difficulty after 1000 %s answers = %d, want %d", tt.result, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowTotals(t *testing.T) {
+	start := time.Now()
+	w := newSlidingWindow(time.Minute, start) // One bucket per second
+	w.add(start, 1, 0, 0)
+	w.add(start.Add(30*time.Second), 0, 2, 0)
+	w.add(start.Add(59*time.Second), 0, 0, 4)
+
+	tests := []struct {
+		at                     time.Duration
+		issued, solved, failed int64
+	}{
+		{at: 59 * time.Second, issued: 1, solved: 2, failed: 4},
+		{at: 60 * time.Second, solved: 2, failed: 4},
+		{at: 89 * time.Second, solved: 2, failed: 4},
+		{at: 90 * time.Second, failed: 4},
+		{at: 118 * time.Second, failed: 4},
+		{at: 119 * time.Second},
+		{at: time.Hour},
+	}
+	for _, tt := range tests {
+		issued, solved, failed := w.totals(start.Add(tt.at))
+		if issued != tt.issued || solved != tt.solved || failed != tt.failed {
+			t.Errorf("totals at %v = %d, %d, %d, want %d, %d, %d", tt.at, issued, solved, failed, tt.issued, tt.solved, tt.failed)
+		}
+	}
+
+	// A bucket reused for a later slot drops the counts of its previous slot
+	w.add(start.Add(time.Hour), 8, 0, 0)
+	if issued, solved, failed := w.totals(start.Add(time.Hour)); issued != 8 || solved != 0 || failed != 0 {
+		t.Errorf("totals after reusing a bucket = %d, %d, %d, want 8, 0, 0", issued, solved, failed)
+	}
+}
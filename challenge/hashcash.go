@@ -0,0 +1,81 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/lib"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+const (
+	// hashcashPrefixLength is the size of the random prefix in bytes.
+	hashcashPrefixLength = 16
+	// maxHashPuzzleBits is the largest difficulty a puzzle on a 256-bit hash can have.
+	maxHashPuzzleBits = config.MaxHashPuzzleBits
+	// maxNonceLength bounds the nonce accepted from clients.
+	maxNonceLength = 64
+	// defaultHashcashDifficulty is used when no hashcash difficulty is configured.
+	defaultHashcashDifficulty = 20
+)
+
+// newHashcashChallenge creates a puzzle asking for a nonce such that
+// SHA-256(prefix || nonce) has at least T leading zero bits.
+func newHashcashChallenge(opts ChallengeOptions) (*types.Challenge, error) {
+	prefix := make([]byte, hashcashPrefixLength)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate prefix: %v", err)
+	}
+
+	diff := config.GlobalConfig.HashcashDifficulty
+	if diff <= 0 {
+		diff = defaultHashcashDifficulty
+	}
+	if opts.Difficulty != nil {
+		diff = *opts.Difficulty
+	}
+
+	return &types.Challenge{
+		ID:        lib.GenerateRandomID(),
+		Type:      types.PuzzleHashcash,
+		Prefix:    prefix,
+		T:         diff,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// leadingZeroBits counts the leading zero bits of a digest.
+func leadingZeroBits(digest []byte) int {
+	count := 0
+	for _, b := range digest {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// VerifyHashcash reports whether SHA-256(prefix || nonce) has at least
+// difficulty leading zero bits.
+func VerifyHashcash(prefix, nonce []byte, difficulty int64) bool {
+	h := sha256.New()
+	h.Write(prefix)
+	h.Write(nonce)
+	return int64(leadingZeroBits(h.Sum(nil))) >= difficulty
+}
+
+// verifyHashcash verifies a nonce submitted for a hashcash challenge.
+func (cm *ChallengeManager) verifyHashcash(ctx context.Context, challenge *types.Challenge, solution Solution) (VerifyResult, error) {
+	if solution.Nonce == "" || len(solution.Nonce) > maxNonceLength {
+		return ResultMalformed, fmt.Errorf("%w: nonce must be between 1 and %d bytes", ErrMalformed, maxNonceLength)
+	}
+	return cm.consume(ctx, challenge.ID, func() bool {
+		return VerifyHashcash(challenge.Prefix, []byte(solution.Nonce), challenge.T)
+	})
+}
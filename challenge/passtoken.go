@@ -0,0 +1,131 @@
+package challenge
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/token"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// DefaultPassTokenTTL is how long pass tokens remain valid unless configured otherwise.
+const DefaultPassTokenTTL = 2 * time.Minute
+
+// Errors returned by RedeemPassToken, besides those of VerifyPassToken.
+var (
+	ErrTokenRedeemed = errors.New("pass token already redeemed")
+	ErrSiteMismatch  = errors.New("pass token was issued for another site")
+)
+
+// PassTokenTTL returns how long pass tokens remain valid after being
+// issued: the configured pass_token_ttl, or DefaultPassTokenTTL.
+func PassTokenTTL() time.Duration {
+	if ttl := config.GlobalConfig.PassTokenTTL; ttl > 0 {
+		return ttl
+	}
+	return DefaultPassTokenTTL
+}
+
+// VerifyAndIssueToken verifies a solution like VerifyChallenge and, if it
+// is valid, returns a pass token proving that the challenge was solved.
+// An error along with ResultValid means the solution was valid, and the
+// challenge used, but no token could be issued.
+func (cm *ChallengeManager) VerifyAndIssueToken(ctx context.Context, id, siteKey string, solution Solution) (VerifyResult, string, error) {
+	challenge, result, err := cm.verify(ctx, id, siteKey, solution)
+	if result != ResultValid {
+		return result, "", err
+	}
+	passToken, err := cm.issuePassToken(ctx, challenge)
+	if err != nil {
+		return ResultValid, "", fmt.Errorf("failed to issue pass token: %w", err)
+	}
+	return ResultValid, passToken, nil
+}
+
+// issuePassToken signs a pass token for a solved challenge.
+func (cm *ChallengeManager) issuePassToken(ctx context.Context, challenge *types.Challenge) (string, error) {
+	signingKey, err := cm.keyManager.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return token.Sign(token.Claims{
+		ChallengeID: challenge.ID,
+		Issuer:      token.Issuer,
+		Site:        challenge.Site,
+		Hostname:    challenge.Hostname,
+		Action:      challenge.Action,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(PassTokenTTL()).Unix(),
+	}, signingKey.ID, signingKey.Private)
+}
+
+// VerifyPassToken checks a pass token issued by this server and returns
+// its claims. Errors wrap the errors of the token package.
+func (cm *ChallengeManager) VerifyPassToken(ctx context.Context, passToken string) (*token.Claims, error) {
+	return token.Verify(passToken, func(keyID string) (ed25519.PublicKey, error) {
+		return cm.keyManager.VerifyingKey(ctx, keyID)
+	}, time.Now())
+}
+
+// RedeemPassToken checks a pass token like VerifyPassToken, checks that it
+// was issued for the site with key site and marks it as used, so that it is redeemed at most
+// once. Tokens issued for another site are left unused.
+func (cm *ChallengeManager) RedeemPassToken(ctx context.Context, passToken, site string) (*token.Claims, error) {
+	claims, err := cm.VerifyPassToken(ctx, passToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Site != site {
+		return nil, fmt.Errorf("%w: %q", ErrSiteMismatch, claims.Site)
+	}
+	redeemed, err := cm.challengeStorage.Redeem(ctx, claims.ChallengeID, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem pass token: %w", err)
+	}
+	if !redeemed {
+		return nil, ErrTokenRedeemed
+	}
+	return claims, nil
+}
+
+// PassTokenKeys returns the public keys pass tokens may currently be signed with.
+func (cm *ChallengeManager) PassTokenKeys(ctx context.Context) (token.KeySet, error) {
+	return cm.keyManager.VerifyingKeys(ctx)
+}
+
+// VerifyAndIssueToken verifies a challenge and issues a pass token using the global manager.
+func VerifyAndIssueToken(ctx context.Context, id, siteKey string, solution Solution) (VerifyResult, string, error) {
+	if globalManager == nil {
+		return ResultUnavailable, "", fmt.Errorf("%w: not initialized", ErrUnavailable)
+	}
+	return globalManager.VerifyAndIssueToken(ctx, id, siteKey, solution)
+}
+
+// VerifyPassToken checks a pass token using the global manager.
+func VerifyPassToken(ctx context.Context, passToken string) (*token.Claims, error) {
+	if globalManager == nil {
+		return nil, fmt.Errorf("challenge storage not initialized")
+	}
+	return globalManager.VerifyPassToken(ctx, passToken)
+}
+
+// RedeemPassToken redeems a pass token using the global manager.
+func RedeemPassToken(ctx context.Context, passToken, site string) (*token.Claims, error) {
+	if globalManager == nil {
+		return nil, fmt.Errorf("challenge storage not initialized")
+	}
+	return globalManager.RedeemPassToken(ctx, passToken, site)
+}
+
+// PassTokenKeys returns the pass token public keys using the global manager.
+func PassTokenKeys(ctx context.Context) (token.KeySet, error) {
+	if globalManager == nil {
+		return nil, fmt.Errorf("challenge storage not initialized")
+	}
+	return globalManager.PassTokenKeys(ctx)
+}
@@ -0,0 +1,69 @@
+package challenge
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// pietrzakChallengeBits is the bit length of the Fiat-Shamir challenges r_i.
+const pietrzakChallengeBits = 128
+
+// pietrzakChallenge derives the challenge r for one halving round from the
+// modulus n, the current claim (x, y, t) and the midpoint mu. The prover must
+// use the same derivation: SHA-256 over the length-prefixed big-endian bytes
+// of n, x, y and mu, followed by t as an 8-byte big-endian integer, truncated
+// to 128 bits. Like hashToPrime, binding n keeps a proof from being replayed
+// under another modulus.
+func pietrzakChallenge(n, x, y, mu *big.Int, t int64) *big.Int {
+	h := sha256.New()
+	writeLengthPrefixed(h, n.Bytes())
+	writeLengthPrefixed(h, x.Bytes())
+	writeLengthPrefixed(h, y.Bytes())
+	writeLengthPrefixed(h, mu.Bytes())
+	binary.Write(h, binary.BigEndian, t)
+	digest := h.Sum(nil)
+	return new(big.Int).SetBytes(digest[:pietrzakChallengeBits/8])
+}
+
+// VerifyPietrzak checks a Pietrzak proof that y = g^(2^t) mod n.
+//
+// Each round halves the claim x^(2^t) = y using the midpoint mu = x^(2^(t/2)):
+// when t is odd the claim is first rewritten as x^(2^(t+1)) = y^2, then
+// x' = x^r * mu, y' = mu^r * y and t' = t/2 with r from pietrzakChallenge.
+// The proof holds exactly one midpoint per round and the final claim must
+// satisfy x^2 = y.
+func VerifyPietrzak(n, g, y *big.Int, t int64, midpoints []*big.Int) bool {
+	if t < 1 || !inGroup(g, n) || !inGroup(y, n) {
+		return false
+	}
+
+	x := new(big.Int).Set(g)
+	y = new(big.Int).Set(y)
+	for _, mu := range midpoints {
+		if t == 1 || !inGroup(mu, n) {
+			return false
+		}
+		if t%2 == 1 {
+			y.Exp(y, big.NewInt(2), n)
+			t++
+		}
+
+		r := pietrzakChallenge(n, x, y, mu, t)
+
+		x.Exp(x, r, n)
+		x.Mul(x, mu)
+		x.Mod(x, n)
+
+		muR := new(big.Int).Exp(mu, r, n)
+		y.Mul(y, muR)
+		y.Mod(y, n)
+
+		t /= 2
+	}
+	if t != 1 {
+		return false
+	}
+
+	return new(big.Int).Exp(x, big.NewInt(2), n).Cmp(y) == 0
+}
@@ -0,0 +1,68 @@
+package challenge
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+// proverMidpoints computes a Pietrzak proof the way the README tells
+// clients to, independently of pietrzakChallenge.
+func proverMidpoints(n, g, y *big.Int, t int64) []*big.Int {
+	x, y := new(big.Int).Set(g), new(big.Int).Set(y)
+	var midpoints []*big.Int
+	for t > 1 {
+		if t%2 == 1 {
+			y.Exp(y, big.NewInt(2), n)
+			t++
+		}
+		mu := new(big.Int).Exp(x, new(big.Int).Lsh(big.NewInt(1), uint(t/2)), n)
+		midpoints = append(midpoints, mu)
+
+		var input []byte
+		for _, v := range []*big.Int{n, x, y, mu} {
+			input = binary.BigEndian.AppendUint32(input, uint32(len(v.Bytes())))
+			input = append(input, v.Bytes()...)
+		}
+		input = binary.BigEndian.AppendUint64(input, uint64(t))
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(digest[:16])
+
+		x.Mul(x.Exp(x, r, n), mu).Mod(x, n)
+		y.Mul(y, new(big.Int).Exp(mu, r, n)).Mod(y, n)
+		t /= 2
+	}
+	return midpoints
+}
+
+func TestVerifyPietrzak(t *testing.T) {
+	p, err := rand.Prime(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := rand.Prime(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := new(big.Int).Mul(p, q)
+	g := big.NewInt(4)
+	const steps = 1001
+
+	y := new(big.Int).Exp(g, new(big.Int).Lsh(big.NewInt(1), steps), n)
+	midpoints := proverMidpoints(n, g, y, steps)
+
+	if !VerifyPietrzak(n, g, y, steps, midpoints) {
+		t.Fatal("proof derived as documented is rejected")
+	}
+	if VerifyPietrzak(n, g, y, steps+1, midpoints) {
+		t.Error("proof is accepted for another difficulty")
+	}
+	if VerifyPietrzak(n, g, new(big.Int).Add(y, big.NewInt(1)), steps, midpoints) {
+		t.Error("proof is accepted for another output")
+	}
+	if VerifyPietrzak(n, g, y, steps, midpoints[:len(midpoints)-1]) {
+		t.Error("proof with a missing midpoint is accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package challenge
+
+import (
+	"math/big"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// Supported proof schemes.
+const (
+	ProofWesolowski = "wesolowski"
+	ProofPietrzak   = "pietrzak"
+)
+
+// ProofVerifier checks a proof that y = g^(2^t) mod n using public values only.
+type ProofVerifier func(n, g, y *big.Int, t int64, proof []*big.Int) bool
+
+var (
+	proofVerifiers = map[string]ProofVerifier{
+		ProofWesolowski: verifyWesolowskiProof,
+		ProofPietrzak:   VerifyPietrzak,
+	}
+	proofVerifiersMutex sync.RWMutex
+)
+
+// RegisterProofVerifier adds or replaces the verifier for a proof scheme.
+func RegisterProofVerifier(scheme string, verifier ProofVerifier) {
+	proofVerifiersMutex.Lock()
+	defer proofVerifiersMutex.Unlock()
+	proofVerifiers[scheme] = verifier
+}
+
+// ProofSchemes returns the proof schemes accepted by the server. These are
+// the schemes listed in the configuration, or every registered scheme if
+// none are configured.
+func ProofSchemes() []string {
+	proofVerifiersMutex.RLock()
+	defer proofVerifiersMutex.RUnlock()
+
+	schemes := make([]string, 0, len(proofVerifiers))
+	for scheme := range proofVerifiers {
+		if len(config.GlobalConfig.ProofSchemes) == 0 || slices.Contains(config.GlobalConfig.ProofSchemes, scheme) {
+			schemes = append(schemes, scheme)
+		}
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// classGroupProofSchemes returns the accepted proof schemes that class group
+// challenges can be proven with. Only Wesolowski proofs are implemented for
+// class groups.
+func classGroupProofSchemes() []string {
+	if slices.Contains(ProofSchemes(), ProofWesolowski) {
+		return []string{ProofWesolowski}
+	}
+	return nil
+}
+
+// getProofVerifier returns the verifier for scheme if the scheme is accepted.
+func getProofVerifier(scheme string) (ProofVerifier, bool) {
+	if len(config.GlobalConfig.ProofSchemes) > 0 && !slices.Contains(config.GlobalConfig.ProofSchemes, scheme) {
+		return nil, false
+	}
+	proofVerifiersMutex.RLock()
+	defer proofVerifiersMutex.RUnlock()
+	verifier, ok := proofVerifiers[scheme]
+	return verifier, ok
+}
+
+// verifyWesolowskiProof adapts VerifyWesolowski to the ProofVerifier signature.
+func verifyWesolowskiProof(n, g, y *big.Int, t int64, proof []*big.Int) bool {
+	if len(proof) != 1 {
+		return false
+	}
+	return VerifyWesolowski(n, g, y, proof[0], t)
+}
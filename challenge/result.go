@@ -0,0 +1,52 @@
+package challenge
+
+import "errors"
+
+// VerifyResult is the outcome of verifying a solution to a challenge.
+type VerifyResult int8
+
+const (
+	ResultInvalid        VerifyResult = iota // Well-formed solution that does not solve the challenge
+	ResultValid                              // Solution solves the challenge
+	ResultNotFound                           // No such challenge, or it was used before
+	ResultMalformed                          // Solution cannot be parsed; the challenge is left in place
+	ResultKeyUnavailable                     // Key the challenge was issued on is gone
+	ResultExpired                            // Challenge expired before the solution arrived
+	ResultUnavailable                        // Storage did not respond in time
+	ResultAlreadyUsed                        // Challenge was used by a concurrent verification
+)
+
+// Errors returned alongside the results other than ResultValid and
+// ResultInvalid. Their messages are safe to show to clients; details
+// specific to a request are wrapped around them.
+var (
+	ErrNotFound       = errors.New("challenge not found")
+	ErrMalformed      = errors.New("malformed solution")
+	ErrKeyUnavailable = errors.New("challenge key is no longer available, request a new challenge")
+	ErrExpired        = errors.New("challenge expired")
+	ErrUnavailable    = errors.New("challenge storage unavailable")
+	ErrAlreadyUsed    = errors.New("challenge already used")
+)
+
+func (r VerifyResult) String() string {
+	switch r {
+	case ResultInvalid:
+		return "invalid"
+	case ResultValid:
+		return "valid"
+	case ResultNotFound:
+		return "not_found"
+	case ResultMalformed:
+		return "malformed"
+	case ResultKeyUnavailable:
+		return "key_unavailable"
+	case ResultExpired:
+		return "expired"
+	case ResultUnavailable:
+		return "unavailable"
+	case ResultAlreadyUsed:
+		return "already_used"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,68 @@
+package challenge
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ucaptcha/backend-go/keys"
+	"github.com/ucaptcha/backend-go/lib"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// benchDifficulty is the T of the benchmarked challenges.
+const benchDifficulty = 100000
+
+// newBenchChallenge creates an RSA key and a challenge on it, along with
+// the correct answer.
+func newBenchChallenge(b *testing.B) (*keys.KeyManager, *storage.KeyPair, *types.Challenge, *big.Int) {
+	km := keys.NewKeyManager(storage.NewMemoryKeyStorage(), keys.Options{KeyLength: 2048})
+	key, err := km.AddKey(b.Context())
+	if err != nil {
+		b.Fatal(err)
+	}
+	n := key.Components.N
+	challenge := &types.Challenge{
+		Type:   types.PuzzleVDF,
+		Scheme: types.SchemeRSA,
+		T:      benchDifficulty,
+		G:      lib.GenerateValidG(n),
+		N:      n,
+		KeyID:  key.ID,
+	}
+
+	// y = g^(2^T mod φ(N)) mod N
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(key.Components.P, one), new(big.Int).Sub(key.Components.Q, one))
+	e := new(big.Int).Exp(big.NewInt(2), big.NewInt(benchDifficulty), phi)
+	y := new(big.Int).Exp(challenge.G, e, n)
+	return km, key, challenge, y
+}
+
+// BenchmarkVerifyCRT compares verifying with the cached verification
+// context of a key against building a new context for every answer.
+func BenchmarkVerifyCRT(b *testing.B) {
+	km, key, challenge, y := newBenchChallenge(b)
+
+	b.Run("Hit", func(b *testing.B) {
+		km.VerificationContext(key)
+		for b.Loop() {
+			if !verifyCRT(km.VerificationContext(key), challenge, y) {
+				b.Fatal("correct answer rejected")
+			}
+		}
+	})
+
+	b.Run("Cold", func(b *testing.B) {
+		i := 0
+		for b.Loop() {
+			// A key ID never seen before misses the context cache
+			cold := &storage.KeyPair{ID: fmt.Sprintf("%s-%d", key.ID, i), Components: key.Components}
+			i++
+			if !verifyCRT(km.VerificationContext(cold), challenge, y) {
+				b.Fatal("correct answer rejected")
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package challenge
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/big"
+)
+
+// wesolowskiPrimeBits is the bit length of the Fiat-Shamir prime l.
+const wesolowskiPrimeBits = 128
+
+// hashToPrime derives the Fiat-Shamir prime l from the encoded group,
+// challenge input and claimed output, followed by the difficulty t. Binding
+// the group keeps a proof from being reused under another modulus or
+// discriminant. The prover must use the same derivation: SHA-256 over the
+// length-prefixed parts, followed by t and a counter as 8-byte big-endian
+// integers. The first 128 bits of the digest, with the top bit set, are
+// tested for primality and the counter is incremented until a prime is found.
+func hashToPrime(t int64, parts ...[]byte) *big.Int {
+	var counter uint64
+	for {
+		h := sha256.New()
+		for _, part := range parts {
+			writeLengthPrefixed(h, part)
+		}
+		binary.Write(h, binary.BigEndian, t)
+		binary.Write(h, binary.BigEndian, counter)
+		digest := h.Sum(nil)
+
+		candidate := new(big.Int).SetBytes(digest[:wesolowskiPrimeBits/8])
+		candidate.SetBit(candidate, wesolowskiPrimeBits-1, 1)
+		if candidate.ProbablyPrime(20) {
+			return candidate
+		}
+		counter++
+	}
+}
+
+// writeLengthPrefixed writes b preceded by its length as a 4-byte big-endian integer.
+func writeLengthPrefixed(w io.Writer, b []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(b)))
+	w.Write(length[:])
+	w.Write(b)
+}
+
+// VerifyWesolowski checks a Wesolowski proof that y = g^(2^t) mod n.
+// Only the public modulus is needed, so nodes that do not hold the
+// factorization of n can verify solutions.
+func VerifyWesolowski(n, g, y, pi *big.Int, t int64) bool {
+	if t < 0 || !inGroup(y, n) || !inGroup(pi, n) {
+		return false
+	}
+
+	l := hashToPrime(t, n.Bytes(), g.Bytes(), y.Bytes())
+	r := new(big.Int).Exp(big.NewInt(2), big.NewInt(t), l)
+
+	// y must equal pi^l * g^r mod n
+	lhs := new(big.Int).Exp(pi, l, n)
+	lhs.Mul(lhs, new(big.Int).Exp(g, r, n))
+	lhs.Mod(lhs, n)
+
+	return lhs.Cmp(y) == 0
+}
+
+// inGroup reports whether x lies in [1, n-1].
+func inGroup(x, n *big.Int) bool {
+	return x.Sign() > 0 && x.Cmp(n) < 0
+}
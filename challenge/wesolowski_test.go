@@ -0,0 +1,58 @@
+package challenge
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+// proverPrime derives the Fiat-Shamir prime the way the README tells
+// clients to, independently of hashToPrime.
+func proverPrime(t int64, n, g, y *big.Int) *big.Int {
+	for counter := uint64(0); ; counter++ {
+		var input []byte
+		for _, x := range []*big.Int{n, g, y} {
+			input = binary.BigEndian.AppendUint32(input, uint32(len(x.Bytes())))
+			input = append(input, x.Bytes()...)
+		}
+		input = binary.BigEndian.AppendUint64(input, uint64(t))
+		input = binary.BigEndian.AppendUint64(input, counter)
+		digest := sha256.Sum256(input)
+
+		l := new(big.Int).SetBytes(digest[:16])
+		l.SetBit(l, 127, 1)
+		if l.ProbablyPrime(20) {
+			return l
+		}
+	}
+}
+
+func TestVerifyWesolowski(t *testing.T) {
+	p, err := rand.Prime(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := rand.Prime(rand.Reader, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := new(big.Int).Mul(p, q)
+	g := big.NewInt(4)
+	const steps = 1000
+
+	y := new(big.Int).Exp(g, new(big.Int).Lsh(big.NewInt(1), steps), n)
+	l := proverPrime(steps, n, g, y)
+	pi := new(big.Int).Exp(g, new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), steps), l), n)
+
+	if !VerifyWesolowski(n, g, y, pi, steps) {
+		t.Fatal("proof derived as documented is rejected")
+	}
+	if VerifyWesolowski(n, g, y, pi, steps+1) {
+		t.Error("proof is accepted for another difficulty")
+	}
+	if VerifyWesolowski(n, g, new(big.Int).Add(y, big.NewInt(1)), pi, steps) {
+		t.Error("proof is accepted for another output")
+	}
+}
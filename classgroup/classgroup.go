@@ -0,0 +1,285 @@
+package classgroup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	one   = big.NewInt(1)
+	two   = big.NewInt(2)
+	four  = big.NewInt(4)
+	eight = big.NewInt(8)
+)
+
+// Form is a binary quadratic form ax^2 + bxy + cy^2 of negative discriminant
+// b^2 - 4ac. Reduced forms are unique representatives of the elements of the
+// class group, so two reduced forms are equal iff their coefficients are.
+type Form struct {
+	A *big.Int
+	B *big.Int
+	C *big.Int
+}
+
+// NewDiscriminant derives a negative prime discriminant of the given bit length
+// from a seed. The seed is expanded with SHA-256 in counter mode, the top bit
+// is set, and the result is adjusted to p ≡ 7 (mod 8) before searching upward
+// for a prime. The discriminant is -p, which is ≡ 1 (mod 8) so that Generator
+// is well defined.
+func NewDiscriminant(seed []byte, bits int) *big.Int {
+	byteLength := (bits + 7) / 8
+	expanded := make([]byte, 0, byteLength+sha256.Size)
+	for counter := uint32(0); len(expanded) < byteLength; counter++ {
+		var c [4]byte
+		binary.BigEndian.PutUint32(c[:], counter)
+		digest := sha256.Sum256(append(c[:], seed...))
+		expanded = append(expanded, digest[:]...)
+	}
+
+	p := new(big.Int).SetBytes(expanded[:byteLength])
+	p.Rsh(p, uint(byteLength*8-bits))
+	p.SetBit(p, bits-1, 1)
+	p.Or(p, big.NewInt(7))
+	for !p.ProbablyPrime(20) {
+		p.Add(p, eight)
+	}
+	return p.Neg(p)
+}
+
+// NewForm builds the form (a, b, c) of discriminant d, deriving c.
+// It fails if a is not positive or no integral c exists.
+func NewForm(a, b, d *big.Int) (*Form, error) {
+	if a.Sign() <= 0 {
+		return nil, fmt.Errorf("form coefficient a must be positive")
+	}
+	// c = (b^2 - d) / 4a
+	num := new(big.Int).Mul(b, b)
+	num.Sub(num, d)
+	den := new(big.Int).Mul(four, a)
+	c, rem := new(big.Int).DivMod(num, den, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, fmt.Errorf("no form with a=%s, b=%s exists for the discriminant", a, b)
+	}
+	return &Form{A: new(big.Int).Set(a), B: new(big.Int).Set(b), C: c}, nil
+}
+
+// Parse decodes a reduced form of discriminant d from its "a,b" string
+// representation. Coefficients longer than the discriminant are rejected
+// before they are parsed, and so are forms that are not reduced, so that
+// every element of the class group has a single encoding.
+func Parse(s string, d *big.Int) (*Form, error) {
+	aStr, bStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid form: %s", s)
+	}
+	aStr, bStr = strings.TrimSpace(aStr), strings.TrimSpace(bStr)
+	// A decimal digit carries more than 3 bits, so this bounds the parsing work
+	maxDigits := d.BitLen()/3 + 2
+	if len(aStr) > maxDigits || len(bStr) > maxDigits {
+		return nil, fmt.Errorf("form coefficients exceed the discriminant")
+	}
+	a, okA := new(big.Int).SetString(aStr, 10)
+	b, okB := new(big.Int).SetString(bStr, 10)
+	if !okA || !okB {
+		return nil, fmt.Errorf("invalid form: %s", s)
+	}
+	if a.BitLen() > d.BitLen() || b.BitLen() > d.BitLen() {
+		return nil, fmt.Errorf("form coefficients exceed the discriminant")
+	}
+	f, err := NewForm(a, b, d)
+	if err != nil {
+		return nil, err
+	}
+	if !f.isReduced() {
+		return nil, fmt.Errorf("form %s is not reduced", s)
+	}
+	return f, nil
+}
+
+// Identity returns the principal form (1, 1, (1 - d) / 4) of discriminant d.
+func Identity(d *big.Int) *Form {
+	c := new(big.Int).Sub(one, d)
+	c.Div(c, four)
+	return &Form{A: big.NewInt(1), B: big.NewInt(1), C: c}
+}
+
+// Generator returns the form (2, 1, (1 - d) / 8), which requires d ≡ 1 (mod 8).
+func Generator(d *big.Int) *Form {
+	c := new(big.Int).Sub(one, d)
+	c.Div(c, eight)
+	return &Form{A: big.NewInt(2), B: big.NewInt(1), C: c}
+}
+
+// String encodes the form as "a,b"; c is implied by the discriminant.
+func (f *Form) String() string {
+	return f.A.String() + "," + f.B.String()
+}
+
+// Discriminant returns b^2 - 4ac.
+func (f *Form) Discriminant() *big.Int {
+	d := new(big.Int).Mul(f.B, f.B)
+	return d.Sub(d, new(big.Int).Mul(four, new(big.Int).Mul(f.A, f.C)))
+}
+
+// Equal reports whether two forms have the same coefficients.
+func (f *Form) Equal(g *Form) bool {
+	return f.A.Cmp(g.A) == 0 && f.B.Cmp(g.B) == 0 && f.C.Cmp(g.C) == 0
+}
+
+// isReduced reports whether f is reduced: -a < b <= a <= c, and b >= 0 if a = c.
+func (f *Form) isReduced() bool {
+	if new(big.Int).Neg(f.A).Cmp(f.B) >= 0 || f.B.Cmp(f.A) > 0 {
+		return false
+	}
+	switch f.A.Cmp(f.C) {
+	case -1:
+		return true
+	case 0:
+		return f.B.Sign() >= 0
+	default:
+		return false
+	}
+}
+
+// normalize returns the equivalent form with -a < b <= a.
+func (f *Form) normalize() *Form {
+	negA := new(big.Int).Neg(f.A)
+	if negA.Cmp(f.B) < 0 && f.B.Cmp(f.A) <= 0 {
+		return f
+	}
+	// r = floor((a - b) / 2a)
+	twoA := new(big.Int).Mul(two, f.A)
+	r := new(big.Int).Sub(f.A, f.B)
+	r.Div(r, twoA)
+
+	// b' = b + 2ra, c' = ar^2 + br + c
+	b := new(big.Int).Mul(twoA, r)
+	b.Add(b, f.B)
+	c := new(big.Int).Mul(f.A, r)
+	c.Add(c, f.B)
+	c.Mul(c, r)
+	c.Add(c, f.C)
+	return &Form{A: new(big.Int).Set(f.A), B: b, C: c}
+}
+
+// Reduce returns the unique reduced form equivalent to f.
+func (f *Form) Reduce() *Form {
+	f = f.normalize()
+	for f.A.Cmp(f.C) > 0 || (f.A.Cmp(f.C) == 0 && f.B.Sign() < 0) {
+		// s = floor((c + b) / 2c)
+		twoC := new(big.Int).Mul(two, f.C)
+		s := new(big.Int).Add(f.C, f.B)
+		s.Div(s, twoC)
+
+		// (a, b, c) <- (c, -b + 2sc, cs^2 - bs + a)
+		b := new(big.Int).Mul(twoC, s)
+		b.Sub(b, f.B)
+		c := new(big.Int).Mul(f.C, s)
+		c.Sub(c, f.B)
+		c.Mul(c, s)
+		c.Add(c, f.A)
+		f = &Form{A: f.C, B: b, C: c}
+	}
+	return f.normalize()
+}
+
+// solveMod solves a*x ≡ b (mod m), returning mu and nu such that the
+// solutions are x = mu + nu*n for any integer n.
+func solveMod(a, b, m *big.Int) (*big.Int, *big.Int, error) {
+	d := new(big.Int)
+	g := new(big.Int).GCD(d, nil, a, m)
+	q, r := new(big.Int).DivMod(b, g, new(big.Int))
+	if r.Sign() != 0 {
+		return nil, nil, fmt.Errorf("no solution to linear congruence")
+	}
+	mu := q.Mul(q, d)
+	mu.Mod(mu, m)
+	nu := new(big.Int).Div(m, g)
+	return mu, nu, nil
+}
+
+// Compose multiplies two forms of the same discriminant and reduces the result.
+func Compose(f1, f2 *Form) (*Form, error) {
+	g := new(big.Int).Add(f1.B, f2.B)
+	g.Div(g, two)
+	h := new(big.Int).Sub(f2.B, f1.B)
+	h.Div(h, two)
+
+	w := new(big.Int).GCD(nil, nil, f1.A, f2.A)
+	w.GCD(nil, nil, w, g)
+
+	j := w
+	s := new(big.Int).Div(f1.A, w)
+	t := new(big.Int).Div(f2.A, w)
+	u := new(big.Int).Div(g, w)
+	st := new(big.Int).Mul(s, t)
+	tu := new(big.Int).Mul(t, u)
+	hu := new(big.Int).Mul(h, u)
+
+	// Solve (tu)k ≡ hu + sc1 (mod st)
+	rhs := new(big.Int).Mul(s, f1.C)
+	rhs.Add(rhs, hu)
+	mu, nu, err := solveMod(tu, rhs, st)
+	if err != nil {
+		return nil, err
+	}
+
+	// Solve (t nu)n ≡ h - t mu (mod s)
+	rhs = new(big.Int).Mul(t, mu)
+	rhs.Sub(h, rhs)
+	lambda, _, err := solveMod(new(big.Int).Mul(t, nu), rhs, s)
+	if err != nil {
+		return nil, err
+	}
+
+	k := new(big.Int).Mul(nu, lambda)
+	k.Add(k, mu)
+
+	// l = (kt - h) / s
+	l := new(big.Int).Mul(k, t)
+	l.Sub(l, h)
+	l.Div(l, s)
+
+	// m = (tuk - hu - c1 s) / st
+	m := new(big.Int).Mul(tu, k)
+	m.Sub(m, hu)
+	m.Sub(m, new(big.Int).Mul(f1.C, s))
+	m.Div(m, st)
+
+	// a3 = st, b3 = ju - (kt + ls), c3 = kl - jm
+	b := new(big.Int).Mul(k, t)
+	b.Add(b, new(big.Int).Mul(l, s))
+	b.Sub(new(big.Int).Mul(j, u), b)
+	c := new(big.Int).Mul(k, l)
+	c.Sub(c, new(big.Int).Mul(j, m))
+
+	return (&Form{A: st, B: b, C: c}).Reduce(), nil
+}
+
+// Square returns f composed with itself.
+func (f *Form) Square() (*Form, error) {
+	return Compose(f, f)
+}
+
+// Pow raises f to a non-negative power by square-and-multiply.
+func (f *Form) Pow(e *big.Int) (*Form, error) {
+	if e.Sign() < 0 {
+		return nil, fmt.Errorf("negative exponent")
+	}
+	result := Identity(f.Discriminant())
+	var err error
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		if result, err = result.Square(); err != nil {
+			return nil, err
+		}
+		if e.Bit(i) == 1 {
+			if result, err = Compose(result, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
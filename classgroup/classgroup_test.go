@@ -0,0 +1,34 @@
+package classgroup
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	d := big.NewInt(-1031)
+	f, err := Generator(d).Pow(big.NewInt(12345))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(f.String(), d)
+	if err != nil {
+		t.Fatalf("Parse(%s) error: %v", f, err)
+	}
+	if !got.Equal(f) {
+		t.Fatalf("Parse(%s) = %s", f, got)
+	}
+
+	for _, s := range []string{
+		"129,-1",                      // a > c
+		"2,5",                         // b > a
+		"1," + strings.Repeat("9", 8), // b longer than the discriminant
+		"3,2",                         // no integral c
+		"2",
+	} {
+		if f, err := Parse(s, d); err == nil {
+			t.Errorf("Parse(%s) = %s, want an error", s, f)
+		}
+	}
+}
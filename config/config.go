@@ -1,31 +1,164 @@
 package config
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
 type RedisConfig struct {
-	Addr     string `mapstructure:"addr"`
-	Password string `mapstructure:"password"`
-	DB       int    `mapstructure:"db"`
+	Addr         string              `mapstructure:"addr"`
+	Username     string              `mapstructure:"username"`
+	Password     string              `mapstructure:"password"`
+	DB           int                 `mapstructure:"db"`
+	Sentinel     RedisSentinelConfig `mapstructure:"sentinel"`
+	Cluster      RedisClusterConfig  `mapstructure:"cluster"`
+	TLS          RedisTLSConfig      `mapstructure:"tls"`
+	PoolSize     int                 `mapstructure:"pool_size"`
+	MinIdleConns int                 `mapstructure:"min_idle_conns"`
+	Prefix       string              `mapstructure:"prefix"`
+}
+
+type RedisSentinelConfig struct {
+	MasterName string   `mapstructure:"master_name"`
+	Addrs      []string `mapstructure:"addrs"`
+	Username   string   `mapstructure:"username"`
+	Password   string   `mapstructure:"password"`
+}
+
+type RedisClusterConfig struct {
+	Addrs []string `mapstructure:"addrs"`
+}
+
+type RedisTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+type SQLConfig struct {
+	Driver string `mapstructure:"driver"`
+	DSN    string `mapstructure:"dsn"`
+}
+
+type BoltConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+type KeyEncryptionConfig struct {
+	MasterKeyFile          string   `mapstructure:"master_key_file"`
+	MasterKeyEnv           string   `mapstructure:"master_key_env"`
+	PreviousMasterKeyFiles []string `mapstructure:"previous_master_key_files"`
+}
+
+type Argon2Config struct {
+	Memory     uint32 `mapstructure:"memory"`
+	Time       uint32 `mapstructure:"time"`
+	Threads    uint8  `mapstructure:"threads"`
+	Difficulty int64  `mapstructure:"difficulty"`
+}
+
+// AdaptiveDifficultyConfig sets up the controller adjusting the default
+// difficulty to the load. A signal is ignored when its high threshold is 0.
+type AdaptiveDifficultyConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	MinDifficulty    int64         `mapstructure:"min_difficulty"`
+	MaxDifficulty    int64         `mapstructure:"max_difficulty"`
+	Window           time.Duration `mapstructure:"window"`
+	Interval         time.Duration `mapstructure:"interval"`
+	Step             float64       `mapstructure:"step"`
+	IssueRateHigh    float64       `mapstructure:"issue_rate_high"`
+	IssueRateLow     float64       `mapstructure:"issue_rate_low"`
+	VerifyRateHigh   float64       `mapstructure:"verify_rate_high"`
+	VerifyRateLow    float64       `mapstructure:"verify_rate_low"`
+	FailureRatioHigh float64       `mapstructure:"failure_ratio_high"`
+	FailureRatioLow  float64       `mapstructure:"failure_ratio_low"`
+	MinSamples       int64         `mapstructure:"min_samples"`
+	Sustain          int           `mapstructure:"sustain"`
+	Cooldown         time.Duration `mapstructure:"cooldown"`
+}
+
+// AuthConfig sets up the credentials clients of the API authenticate with.
+// The API is only left open when Disabled is set.
+type AuthConfig struct {
+	Disabled bool           `mapstructure:"disabled"` // Leaves every route open, without credentials
+	APIKeys  []APIKeyConfig `mapstructure:"api_keys"`
+	HMAC     HMACAuthConfig `mapstructure:"hmac"`
+	MTLS     MTLSAuthConfig `mapstructure:"mtls"`
+}
+
+type APIKeyConfig struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+type HMACAuthConfig struct {
+	Window time.Duration   `mapstructure:"window"`
+	Keys   []HMACKeyConfig `mapstructure:"keys"`
+}
+
+type HMACKeyConfig struct {
+	ID     string   `mapstructure:"id"`
+	Secret string   `mapstructure:"secret"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+type MTLSAuthConfig struct {
+	Clients []MTLSClientConfig `mapstructure:"clients"`
+}
+
+type MTLSClientConfig struct {
+	CommonName string   `mapstructure:"common_name"`
+	Scopes     []string `mapstructure:"scopes"`
+}
+
+type TLSConfig struct {
+	CertFile     string `mapstructure:"cert_file"`
+	KeyFile      string `mapstructure:"key_file"`
+	ClientCAFile string `mapstructure:"client_ca_file"`
 }
 
 type Config struct {
-	ChallengeStorage    string        `mapstructure:"challenge_storage"`
-	KeysStorage         string        `mapstructure:"keys_storage"`
-	Redis               RedisConfig   `mapstructure:"redis"`
-	KeyLength           int           `mapstructure:"key_length"`
-	KeyRotationInterval time.Duration `mapstructure:"key_rotation_interval"`
-	Port                int           `mapstructure:"port"`
-	Host                string        `mapstructure:"host"`
-	KeyPoolSize         int           `mapstructure:"key_pool_size"`
-	Difficulty          int64         `mapstructure:"difficulty"`
+	ChallengeStorage    string                   `mapstructure:"challenge_storage"`
+	KeysStorage         string                   `mapstructure:"keys_storage"`
+	KeyEncryption       KeyEncryptionConfig      `mapstructure:"key_encryption"`
+	Redis               RedisConfig              `mapstructure:"redis"`
+	SQL                 SQLConfig                `mapstructure:"sql"`
+	Bolt                BoltConfig               `mapstructure:"bolt"`
+	KeyLength           int                      `mapstructure:"key_length"`
+	KeyPrimeMode        string                   `mapstructure:"key_prime_mode"`
+	DiscriminantBits    int                      `mapstructure:"class_group_discriminant_bits"`
+	KeyRotationInterval time.Duration            `mapstructure:"key_rotation_interval"`
+	KeyGracePeriod      time.Duration            `mapstructure:"key_grace_period"`
+	ChallengeTTL        time.Duration            `mapstructure:"challenge_ttl"`
+	ChallengeMaxEntries int                      `mapstructure:"challenge_max_entries"`
+	StorageTimeout      time.Duration            `mapstructure:"storage_timeout"`
+	PassTokenTTL        time.Duration            `mapstructure:"pass_token_ttl"`
+	Port                int                      `mapstructure:"port"`
+	Host                string                   `mapstructure:"host"`
+	TLS                 TLSConfig                `mapstructure:"tls"`
+	Auth                AuthConfig               `mapstructure:"auth"`
+	KeyPoolSize         int                      `mapstructure:"key_pool_size"`
+	KeyPregenBuffer     int                      `mapstructure:"key_pregen_buffer"`
+	KeyPregenWorkers    int                      `mapstructure:"key_pregen_workers"`
+	Difficulty          int64                    `mapstructure:"difficulty"`
+	AdaptiveDifficulty  AdaptiveDifficultyConfig `mapstructure:"adaptive_difficulty"`
+	HashcashDifficulty  int64                    `mapstructure:"hashcash_difficulty"`
+	Argon2              Argon2Config             `mapstructure:"argon2"`
+	ProofSchemes        []string                 `mapstructure:"proof_schemes"`
 }
 
 var GlobalConfig Config
 
+// MaxHashPuzzleBits is the largest difficulty of hashcash and Argon2id
+// puzzles, whose hashes are 256 bits long.
+const MaxHashPuzzleBits = 256
+
 func LoadConfig(path string) error {
 	viper.SetConfigFile(path)
 	viper.SetConfigType("yaml") // Or "toml"
@@ -37,6 +170,20 @@ func LoadConfig(path string) error {
 		return err
 	}
 
-	err = viper.Unmarshal(&GlobalConfig)
-	return err
+	if err := viper.Unmarshal(&GlobalConfig); err != nil {
+		return err
+	}
+	return GlobalConfig.validate()
+}
+
+// validate checks the settings that cannot be checked where they are used
+// without failing on every request. Unset difficulties take their defaults.
+func (c *Config) validate() error {
+	if d := c.HashcashDifficulty; d < 0 || d > MaxHashPuzzleBits {
+		return fmt.Errorf("hashcash_difficulty must be between 1 and %d bits", MaxHashPuzzleBits)
+	}
+	if d := c.Argon2.Difficulty; d < 0 || d > MaxHashPuzzleBits {
+		return fmt.Errorf("argon2.difficulty must be between 1 and %d bits", MaxHashPuzzleBits)
+	}
+	return nil
 }
@@ -1,105 +1,224 @@
 package keys
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"log"
+	"math/big"
 	"sync"
 	"time"
 
+	"github.com/ucaptcha/backend-go/classgroup"
 	"github.com/ucaptcha/backend-go/lib"
 	"github.com/ucaptcha/backend-go/storage"
 )
 
+// defaultDiscriminantBits is the class group discriminant size used when none is configured.
+const defaultDiscriminantBits = 1024
+
+// Options configures a KeyManager.
+type Options struct {
+	KeyLength        int    // RSA modulus size in bits
+	PrimeMode        string // PrimeModeStandard (default) or PrimeModeSafe
+	DiscriminantBits int    // Class group discriminant size in bits, 0 selects the default
+	PregenBuffer     int    // Number of RSA keys generated ahead of use, 0 disables pre-generation
+	PregenWorkers    int    // Number of pre-generation workers, defaults to 1
+}
+
 // KeyManager handles key generation, storage, and retrieval
 type KeyManager struct {
-	keyStorage storage.KeyStorage
-	keyLength  int
-	keyMutex   sync.RWMutex // Mutex for key generation/initialization logic
+	keyStorage       storage.KeyStorage
+	keyLength        int
+	primeMode        string
+	discriminantBits int
+	keyMutex         sync.RWMutex // Mutex for key storage modifications
+	recoveryMutex    sync.Mutex   // Serializes key creation when the pool is empty
+	contexts         *lib.LRU[string, *VerificationContext]
+	pregen           *pregenerator // Nil when pre-generation is disabled
 }
 
 // NewKeyManager creates a new KeyManager instance.
-func NewKeyManager(keyStorage storage.KeyStorage, keyLength int) *KeyManager {
-	return &KeyManager{
-		keyStorage: keyStorage,
-		keyLength:  keyLength,
+func NewKeyManager(keyStorage storage.KeyStorage, opts Options) *KeyManager {
+	if opts.PrimeMode == "" {
+		opts.PrimeMode = PrimeModeStandard
+	}
+	if opts.DiscriminantBits <= 0 {
+		opts.DiscriminantBits = defaultDiscriminantBits
+	}
+	km := &KeyManager{
+		keyStorage:       keyStorage,
+		keyLength:        opts.KeyLength,
+		primeMode:        opts.PrimeMode,
+		discriminantBits: opts.DiscriminantBits,
+		contexts:         lib.NewLRU[string, *VerificationContext](verificationContextCacheSize),
+	}
+	if opts.PregenBuffer > 0 {
+		workers := max(opts.PregenWorkers, 1)
+		km.pregen = newPregenerator(opts.PregenBuffer, workers, func() (*storage.KeyPair, error) {
+			return generateNewKey(km.keyLength, km.primeMode)
+		})
+	}
+	return km
+}
+
+// Close stops the background key pre-generation workers, if any.
+func (km *KeyManager) Close() {
+	if km.pregen != nil {
+		km.pregen.stop()
 	}
 }
 
 // generateNewKey generates a new RSA key pair with a unique ID.
-func generateNewKey(keyLength int) (*storage.KeyPair, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, keyLength)
-	if err != nil {
-		return nil, err
+// Verification only relies on g being a quadratic residue, so keys of
+// either prime mode verify the same way.
+func generateNewKey(keyLength int, primeMode string) (*storage.KeyPair, error) {
+	var p, q *big.Int
+	switch primeMode {
+	case PrimeModeStandard:
+		privateKey, err := rsa.GenerateKey(rand.Reader, keyLength)
+		if err != nil {
+			return nil, err
+		}
+		p, q = privateKey.Primes[0], privateKey.Primes[1]
+	case PrimeModeSafe:
+		var err error
+		if p, q, err = generateSafePrimes(keyLength); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown prime mode: %s", primeMode)
 	}
 
 	keyID := lib.GenerateRandomID()
 	return &storage.KeyPair{
-		ID: keyID,
+		ID:    keyID,
+		Type:  storage.KeyTypeRSA,
+		State: storage.KeyStateActive,
 		Components: storage.RSAComponents{
-			P: privateKey.Primes[0],
-			Q: privateKey.Primes[1],
-			N: privateKey.N,
+			P: p,
+			Q: q,
+			N: new(big.Int).Mul(p, q),
+		},
+		GeneratedAt: time.Now(),
+	}, nil
+}
+
+// generateClassGroupKey derives a class group discriminant from a random seed.
+// Class group keys carry no secret: anyone can recompute the discriminant.
+func generateClassGroupKey(bits int) (*storage.KeyPair, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+
+	return &storage.KeyPair{
+		ID:           lib.GenerateRandomID(),
+		Type:         storage.KeyTypeClassGroup,
+		State:        storage.KeyStateActive,
+		Discriminant: classgroup.NewDiscriminant(seed, bits),
+		GeneratedAt:  time.Now(),
+	}, nil
+}
+
+// generateSigningKey generates an Ed25519 key pair for signing pass tokens.
+func generateSigningKey() (*storage.KeyPair, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.KeyPair{
+		ID:    lib.GenerateRandomID(),
+		Type:  storage.KeyTypeEd25519,
+		State: storage.KeyStateActive,
+		Ed25519: &storage.Ed25519Components{
+			Public:  public,
+			Private: private.Seed(),
 		},
 		GeneratedAt: time.Now(),
 	}, nil
 }
 
-// GetKey retrieves a key by its ID.
-func (km *KeyManager) GetKey(id string) (*storage.KeyPair, error) {
-	return km.keyStorage.GetKey(id)
+// generateKey generates a new key of the given type.
+func (km *KeyManager) generateKey(keyType storage.KeyType) (*storage.KeyPair, error) {
+	switch keyType {
+	case storage.KeyTypeRSA:
+		return generateNewKey(km.keyLength, km.primeMode)
+	case storage.KeyTypeClassGroup:
+		return generateClassGroupKey(km.discriminantBits)
+	case storage.KeyTypeEd25519:
+		return generateSigningKey()
+	default:
+		return nil, fmt.Errorf("unknown key type: %s", keyType)
+	}
 }
 
-// GetRandomKey retrieves a random key from storage.
-// If no keys exist, it generates a new one, saves it, and returns it.
-func (km *KeyManager) GetRandomKey() (*storage.KeyPair, error) {
+// GetKey retrieves a key by its ID for verification.
+// Active and verify-only keys are returned, retired keys are not.
+func (km *KeyManager) GetKey(ctx context.Context, id string) (*storage.KeyPair, error) {
+	key, err := km.keyStorage.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.GetState() == storage.KeyStateRetired {
+		return nil, fmt.Errorf("key %s is retired", id)
+	}
+	return key, nil
+}
+
+// GetRandomKey retrieves a random key of the given type from storage.
+// If no such key exists, it generates a new one, saves it, and returns it.
+func (km *KeyManager) GetRandomKey(ctx context.Context, keyType storage.KeyType) (*storage.KeyPair, error) {
 	km.keyMutex.RLock()
-	hasKey, err := km.keyStorage.HasKey()
+	hasKey, err := km.keyStorage.HasKey(ctx)
 	km.keyMutex.RUnlock()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key count: %v", err)
+		return nil, fmt.Errorf("failed to get key count: %w", err)
 	}
 
 	if hasKey {
 		km.keyMutex.RLock()
-		randomKey, err := km.keyStorage.GetRandomKey()
+		randomKey, err := km.keyStorage.GetRandomKey(ctx, keyType)
 		km.keyMutex.RUnlock()
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to get random key: %v", err)
+			return nil, fmt.Errorf("failed to get random key: %w", err)
 		}
 		if randomKey != nil {
 			return randomKey, nil
 		}
 	}
 
-	// No keys found, need to generate one. Acquire write lock.
-	km.keyMutex.Lock()
-	defer km.keyMutex.Unlock()
+	// No keys found, need to create one. Only one goroutine recovers at a
+	// time, without holding keyMutex so that requests finding keys are not blocked.
+	km.recoveryMutex.Lock()
+	defer km.recoveryMutex.Unlock()
 
-	// Double-check if another goroutine generated a key while waiting for the lock
-	hasKey, err = km.keyStorage.HasKey()
+	// Double-check if another goroutine created a key while waiting for the lock
+	km.keyMutex.RLock()
+	randomKey, err := km.keyStorage.GetRandomKey(ctx, keyType)
+	km.keyMutex.RUnlock()
 	if err != nil {
-	} else if hasKey {
-		randomKey, err := km.keyStorage.GetRandomKey()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get random key: %v", err)
-		}
-		if randomKey != nil {
-			return randomKey, nil
-		}
+		return nil, fmt.Errorf("failed to get random key: %w", err)
+	}
+	if randomKey != nil {
+		return randomKey, nil
 	}
 
-	// Still no keys, generate, save, and return a new one
-	log.Println("No keys found in storage. Generating a new key.")
-	newKey, err := generateNewKey(km.keyLength)
+	// Still no keys, create, save, and return a new one
+	log.Printf("No %s keys found in storage. Creating a new key.", keyType)
+	newKey, err := km.newKey(keyType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate new key: %v", err)
 	}
 
-	err = km.keyStorage.SaveKey(newKey)
+	km.keyMutex.Lock()
+	err = km.keyStorage.SaveKey(ctx, newKey)
+	km.keyMutex.Unlock()
 	if err != nil {
 		log.Printf("Warning: Failed to save newly generated key: %v", err)
 	}
@@ -107,41 +226,55 @@ func (km *KeyManager) GetRandomKey() (*storage.KeyPair, error) {
 	return newKey, nil
 }
 
-// AddKey generates a new key and saves it to storage.
-func (km *KeyManager) AddKey() (*storage.KeyPair, error) {
-	km.keyMutex.Lock() // Lock needed as it modifies storage
-	defer km.keyMutex.Unlock()
+// newKey returns a new key of the given type, taking RSA keys from the
+// pre-generated buffer when one is available.
+func (km *KeyManager) newKey(keyType storage.KeyType) (*storage.KeyPair, error) {
+	if keyType == storage.KeyTypeRSA && km.pregen != nil {
+		if key, ok := km.pregen.take(); ok {
+			return key, nil
+		}
+	}
+	return km.generateKey(keyType)
+}
 
-	newKey, err := generateNewKey(km.keyLength)
+// AddKey creates a new RSA key and saves it to storage.
+func (km *KeyManager) AddKey(ctx context.Context) (*storage.KeyPair, error) {
+	// Generate before locking so that a slow generation does not block readers
+	newKey, err := km.newKey(storage.KeyTypeRSA)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate new key: %v", err)
 	}
-	err = km.keyStorage.SaveKey(newKey)
+
+	km.keyMutex.Lock() // Lock needed as it modifies storage
+	defer km.keyMutex.Unlock()
+
+	err = km.keyStorage.SaveKey(ctx, newKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save new key: %v", err)
+		return nil, fmt.Errorf("failed to save new key: %w", err)
 	}
 	log.Printf("Added new key with ID: %s", newKey.ID)
 	return newKey, nil
 }
 
 // RemoveKey removes a key by its ID.
-func (km *KeyManager) RemoveKey(id string) error {
+func (km *KeyManager) RemoveKey(ctx context.Context, id string) error {
 	km.keyMutex.Lock() // Lock needed as it modifies storage
 	defer km.keyMutex.Unlock()
 
-	err := km.keyStorage.DeleteKey(id)
+	err := km.keyStorage.DeleteKey(ctx, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete key %s: %v", id, err)
 	}
+	km.contexts.Remove(id)
 	log.Printf("Removed key with ID: %s", id)
 	return nil
 }
 
 // Get key count
-func (km *KeyManager) GetKeyCount() (int, error) {
+func (km *KeyManager) GetKeyCount(ctx context.Context) (int, error) {
 	km.keyMutex.RLock() // Lock needed as it modifies storage
 	defer km.keyMutex.RUnlock()
-	keyCounts, err := km.keyStorage.GetKeyCount()
+	keyCounts, err := km.keyStorage.GetKeyCount(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,143 @@
+package keys
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// ActiveKeyCount returns the number of active keys of the given type.
+func (km *KeyManager) ActiveKeyCount(ctx context.Context, keyType storage.KeyType) (int, error) {
+	km.keyMutex.RLock()
+	defer km.keyMutex.RUnlock()
+	allKeys, err := km.keyStorage.GetAllKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range allKeys {
+		if key.GetType() == keyType && key.GetState() == storage.KeyStateActive {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// setKeyState moves a key to a new state and saves it.
+func (km *KeyManager) setKeyState(ctx context.Context, key *storage.KeyPair, state storage.KeyState) error {
+	updated := *key
+	updated.State = state
+	updated.StateChangedAt = time.Now()
+
+	km.keyMutex.Lock() // Lock needed as it modifies storage
+	defer km.keyMutex.Unlock()
+	if err := km.keyStorage.SaveKey(ctx, &updated); err != nil {
+		return fmt.Errorf("failed to update state of key %s: %v", key.ID, err)
+	}
+	return nil
+}
+
+// DeactivateKey moves a key to the verify-only state: no new challenges are
+// issued on it, but challenges already issued still verify.
+func (km *KeyManager) DeactivateKey(ctx context.Context, id string) error {
+	key, err := km.keyStorage.GetKey(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get key %s: %v", id, err)
+	}
+	if err := km.setKeyState(ctx, key, storage.KeyStateVerifyOnly); err != nil {
+		return err
+	}
+	log.Printf("Key %s is now verify-only", id)
+	return nil
+}
+
+// RetireKey retires a key. Retired keys neither issue nor verify challenges,
+// so their material is deleted from storage.
+func (km *KeyManager) RetireKey(ctx context.Context, id string) error {
+	if err := km.RemoveKey(ctx, id); err != nil {
+		return err
+	}
+	log.Printf("Retired key %s", id)
+	return nil
+}
+
+// Rotate runs one rotation of every key type, on the key_rotation_interval:
+//
+//   - RSA: a new key is added and the oldest active key is moved to the
+//     verify-only state, so the pool keeps its size.
+//   - Class group and Ed25519: once in use, every active key is replaced by
+//     a single new one. Types never used hold no key and are skipped, as
+//     their first key is created on demand.
+//
+// Verify-only keys of every type are retired once they have been in that
+// state for longer than gracePeriod. gracePeriod must be at least as long as
+// challenges and pass tokens live, so that everything issued on a key can
+// still be verified until it expires.
+func (km *KeyManager) Rotate(ctx context.Context, gracePeriod time.Duration) error {
+	allKeys, err := km.keyStorage.GetAllKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get all keys: %v", err)
+	}
+
+	if _, err := km.AddKey(ctx); err != nil {
+		return err
+	}
+
+	var oldestKey *storage.KeyPair
+	replaced := make(map[storage.KeyType][]*storage.KeyPair)
+	for _, key := range allKeys {
+		switch key.GetState() {
+		case storage.KeyStateActive:
+			if key.GetType() != storage.KeyTypeRSA {
+				replaced[key.GetType()] = append(replaced[key.GetType()], key)
+			} else if oldestKey == nil || key.GeneratedAt.Before(oldestKey.GeneratedAt) {
+				oldestKey = key
+			}
+		case storage.KeyStateVerifyOnly, storage.KeyStateRetired:
+			if time.Since(key.StateChangedAt) >= gracePeriod {
+				if err := km.RetireKey(ctx, key.ID); err != nil {
+					log.Printf("Failed to retire key %s: %v", key.ID, err)
+				}
+			}
+		}
+	}
+
+	for _, keyType := range []storage.KeyType{storage.KeyTypeClassGroup, storage.KeyTypeEd25519} {
+		if active := replaced[keyType]; len(active) > 0 {
+			if err := km.replaceKeys(ctx, keyType, active); err != nil {
+				return err
+			}
+		}
+	}
+	if oldestKey != nil {
+		return km.DeactivateKey(ctx, oldestKey.ID)
+	}
+	return nil
+}
+
+// replaceKeys adds a new active key of the given type and moves the given
+// active ones to the verify-only state, so that what they issued or signed
+// remains valid until it expires.
+func (km *KeyManager) replaceKeys(ctx context.Context, keyType storage.KeyType, active []*storage.KeyPair) error {
+	newKey, err := km.generateKey(keyType)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s key: %v", keyType, err)
+	}
+	km.keyMutex.Lock()
+	err = km.keyStorage.SaveKey(ctx, newKey)
+	km.keyMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save %s key: %w", keyType, err)
+	}
+	log.Printf("Added new %s key with ID: %s", keyType, newKey.ID)
+
+	for _, key := range active {
+		if err := km.DeactivateKey(ctx, key.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
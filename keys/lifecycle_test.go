@@ -0,0 +1,79 @@
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// keyStates returns the IDs of the keys of the given type in each state.
+func keyStates(t *testing.T, km *KeyManager, keyType storage.KeyType) map[storage.KeyState][]string {
+	t.Helper()
+	all, err := km.keyStorage.GetAllKeys(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[storage.KeyState][]string)
+	for _, key := range all {
+		if key.GetType() == keyType {
+			states[key.GetState()] = append(states[key.GetState()], key.ID)
+		}
+	}
+	return states
+}
+
+// Every key type in use is replaced on each rotation, and the replaced
+// keys are retired once the grace period has passed.
+func TestRotate(t *testing.T) {
+	for _, keyType := range []storage.KeyType{storage.KeyTypeRSA, storage.KeyTypeClassGroup, storage.KeyTypeEd25519} {
+		t.Run(string(keyType), func(t *testing.T) {
+			km := NewKeyManager(storage.NewMemoryKeyStorage(), Options{KeyLength: 1024, DiscriminantBits: 64})
+			defer km.Close()
+			first, err := km.GetRandomKey(t.Context(), keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := km.Rotate(t.Context(), time.Hour); err != nil {
+				t.Fatalf("Rotate() error: %v", err)
+			}
+			states := keyStates(t, km, keyType)
+			if len(states[storage.KeyStateActive]) != 1 || states[storage.KeyStateActive][0] == first.ID {
+				t.Fatalf("active %s keys after rotation = %v, want one new key", keyType, states[storage.KeyStateActive])
+			}
+			if verifyOnly := states[storage.KeyStateVerifyOnly]; len(verifyOnly) != 1 || verifyOnly[0] != first.ID {
+				t.Fatalf("verify-only %s keys after rotation = %v, want [%s]", keyType, verifyOnly, first.ID)
+			}
+			second := states[storage.KeyStateActive][0]
+
+			if err := km.Rotate(t.Context(), 0); err != nil {
+				t.Fatalf("Rotate() error: %v", err)
+			}
+			states = keyStates(t, km, keyType)
+			if len(states[storage.KeyStateActive]) != 1 {
+				t.Fatalf("active %s keys after second rotation = %v, want one key", keyType, states[storage.KeyStateActive])
+			}
+			if verifyOnly := states[storage.KeyStateVerifyOnly]; len(verifyOnly) != 1 || verifyOnly[0] != second {
+				t.Fatalf("verify-only %s keys after second rotation = %v, want [%s]", keyType, verifyOnly, second)
+			}
+			if _, err := km.GetKey(t.Context(), first.ID); err == nil {
+				t.Fatalf("%s key %s outlived the grace period", keyType, first.ID)
+			}
+		})
+	}
+}
+
+// Key types that were never used get no key from rotation.
+func TestRotateSkipsUnusedTypes(t *testing.T) {
+	km := NewKeyManager(storage.NewMemoryKeyStorage(), Options{KeyLength: 1024, DiscriminantBits: 64})
+	defer km.Close()
+	if err := km.Rotate(t.Context(), time.Hour); err != nil {
+		t.Fatalf("Rotate() error: %v", err)
+	}
+	for _, keyType := range []storage.KeyType{storage.KeyTypeClassGroup, storage.KeyTypeEd25519} {
+		if states := keyStates(t, km, keyType); len(states) != 0 {
+			t.Errorf("rotation created %s keys %v", keyType, states)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package keys
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// pregenRetryDelay is how long a worker waits after a failed generation.
+const pregenRetryDelay = time.Second
+
+// pregenerator runs a pool of workers keeping a bounded buffer of freshly
+// generated keys that are not yet saved to storage, so that rotation and
+// empty-pool recovery rarely have to generate a key inline.
+type pregenerator struct {
+	ready    chan *storage.KeyPair
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newPregenerator starts workers filling a buffer of the given size with
+// keys produced by generate.
+func newPregenerator(buffer, workers int, generate func() (*storage.KeyPair, error)) *pregenerator {
+	pg := &pregenerator{
+		ready: make(chan *storage.KeyPair, buffer),
+		done:  make(chan struct{}),
+	}
+	pg.wg.Add(workers)
+	for range workers {
+		go pg.work(generate)
+	}
+	return pg
+}
+
+// work generates keys until the pregenerator is stopped, blocking while
+// the buffer is full.
+func (pg *pregenerator) work(generate func() (*storage.KeyPair, error)) {
+	defer pg.wg.Done()
+	for {
+		key, err := generate()
+		if err != nil {
+			log.Printf("Failed to pre-generate key: %v", err)
+			select {
+			case <-time.After(pregenRetryDelay):
+				continue
+			case <-pg.done:
+				return
+			}
+		}
+
+		select {
+		case pg.ready <- key:
+		case <-pg.done:
+			return
+		}
+	}
+}
+
+// take returns a buffered key without blocking, or false if none is ready.
+// The key is dated from the time it is taken into service rather than
+// generated, so that rotation does not see a fresh key as the oldest.
+func (pg *pregenerator) take() (*storage.KeyPair, bool) {
+	select {
+	case key := <-pg.ready:
+		key.GeneratedAt = time.Now()
+		return key, true
+	default:
+		return nil, false
+	}
+}
+
+// stop signals the workers to exit and waits for them. A generation in
+// progress is allowed to finish.
+func (pg *pregenerator) stop() {
+	pg.stopOnce.Do(func() {
+		close(pg.done)
+	})
+	pg.wg.Wait()
+}
@@ -0,0 +1,29 @@
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// Keys taken from the buffer are dated from when they enter service.
+func TestPregeneratorTakeDatesKey(t *testing.T) {
+	generatedAt := time.Now().Add(-time.Hour)
+	pg := newPregenerator(1, 1, func() (*storage.KeyPair, error) {
+		return &storage.KeyPair{ID: "buffered", GeneratedAt: generatedAt}, nil
+	})
+	defer pg.stop()
+
+	var key *storage.KeyPair
+	for deadline := time.Now().Add(5 * time.Second); key == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("no key was buffered")
+		}
+		key, _ = pg.take()
+		time.Sleep(time.Millisecond)
+	}
+	if !key.GeneratedAt.After(generatedAt) {
+		t.Fatalf("taken key is dated %v, the time it was generated", key.GeneratedAt)
+	}
+}
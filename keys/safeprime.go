@@ -0,0 +1,143 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"runtime"
+)
+
+// Prime generation modes for RSA keys.
+const (
+	PrimeModeStandard = "standard" // Ordinary primes from rsa.GenerateKey
+	PrimeModeSafe     = "safe"     // Safe primes p = 2p' + 1 with p' prime
+)
+
+// sieveLimit bounds the small primes used to discard candidates cheaply.
+const sieveLimit = 2048
+
+// sieveWindow is the number of odd candidates scanned from one random start.
+const sieveWindow = 1 << 16
+
+// smallPrimes holds the odd primes below sieveLimit.
+var smallPrimes = func() []uint64 {
+	var primes []uint64
+	composite := make([]bool, sieveLimit)
+	for i := 2; i < sieveLimit; i++ {
+		if composite[i] {
+			continue
+		}
+		if i > 2 {
+			primes = append(primes, uint64(i))
+		}
+		for j := i * i; j < sieveLimit; j += i {
+			composite[j] = true
+		}
+	}
+	return primes
+}()
+
+// generateSafePrime returns a safe prime p = 2q + 1 of the given bit length,
+// with its top two bits set so that products of two such primes have exactly
+// twice as many bits. Safe primes are rare, so the search runs on every core
+// and returns as soon as one worker succeeds.
+func generateSafePrime(bits int) (*big.Int, error) {
+	if bits < 16 {
+		return nil, fmt.Errorf("safe prime size too small: %d bits", bits)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	workers := runtime.NumCPU()
+	results := make(chan *big.Int, workers)
+	errs := make(chan error, workers)
+	for range workers {
+		go func() {
+			p, err := searchSafePrime(ctx, bits)
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- p
+		}()
+	}
+
+	select {
+	case p := <-results:
+		return p, nil
+	case err := <-errs:
+		return nil, err
+	}
+}
+
+// searchSafePrime scans windows of candidates q from random starting points
+// until 2q + 1 is a safe prime or ctx is cancelled.
+func searchSafePrime(ctx context.Context, bits int) (*big.Int, error) {
+	qBits := bits - 1
+	residues := make([]uint64, len(smallPrimes))
+	q := new(big.Int)
+	r := new(big.Int)
+
+	for {
+		// Random odd q with its top two bits set
+		start, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(qBits)))
+		if err != nil {
+			return nil, err
+		}
+		start.SetBit(start, qBits-1, 1)
+		start.SetBit(start, qBits-2, 1)
+		start.SetBit(start, 0, 1)
+
+		for i, sp := range smallPrimes {
+			residues[i] = r.Mod(start, r.SetUint64(sp)).Uint64()
+		}
+
+	window:
+		for delta := uint64(0); delta < sieveWindow; delta += 2 {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// Neither q nor 2q + 1 may have a small factor
+			for i, sp := range smallPrimes {
+				rq := (residues[i] + delta) % sp
+				if rq == 0 || (2*rq+1)%sp == 0 {
+					continue window
+				}
+			}
+
+			q.Add(start, new(big.Int).SetUint64(delta))
+			if q.BitLen() != qBits {
+				break
+			}
+			// Cheap tests first, full tests only for likely candidates
+			if !q.ProbablyPrime(1) {
+				continue
+			}
+			p := new(big.Int).Lsh(q, 1)
+			p.SetBit(p, 0, 1)
+			if p.ProbablyPrime(1) && q.ProbablyPrime(20) && p.ProbablyPrime(20) {
+				return p, nil
+			}
+		}
+	}
+}
+
+// generateSafePrimes returns two distinct safe primes whose product has
+// exactly keyLength bits.
+func generateSafePrimes(keyLength int) (*big.Int, *big.Int, error) {
+	p, err := generateSafePrime((keyLength + 1) / 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		q, err := generateSafePrime(keyLength / 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Cmp(q) != 0 {
+			return p, q, nil
+		}
+	}
+}
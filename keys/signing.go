@@ -0,0 +1,58 @@
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// SigningKey is an Ed25519 private key signing pass tokens, along with the
+// ID under which its public key is looked up.
+type SigningKey struct {
+	ID      string
+	Private ed25519.PrivateKey
+}
+
+// SigningKey returns a random active Ed25519 key, creating one if none exists.
+func (km *KeyManager) SigningKey(ctx context.Context) (*SigningKey, error) {
+	key, err := km.GetRandomKey(ctx, storage.KeyTypeEd25519)
+	if err != nil {
+		return nil, err
+	}
+	if key.Ed25519 == nil || len(key.Ed25519.Private) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key %s has no Ed25519 private key", key.ID)
+	}
+	return &SigningKey{ID: key.ID, Private: ed25519.NewKeyFromSeed(key.Ed25519.Private)}, nil
+}
+
+// VerifyingKey returns the public key of the Ed25519 key with the given ID.
+// Like GetKey, it returns active and verify-only keys only.
+func (km *KeyManager) VerifyingKey(ctx context.Context, id string) (ed25519.PublicKey, error) {
+	key, err := km.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.GetType() != storage.KeyTypeEd25519 || key.Ed25519 == nil || len(key.Ed25519.Public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("key %s is not an Ed25519 key", id)
+	}
+	return ed25519.PublicKey(key.Ed25519.Public), nil
+}
+
+// VerifyingKeys returns the public keys of all Ed25519 keys that are active
+// or verify-only, by key ID.
+func (km *KeyManager) VerifyingKeys(ctx context.Context) (map[string]ed25519.PublicKey, error) {
+	allKeys, err := km.keyStorage.GetAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	publicKeys := make(map[string]ed25519.PublicKey)
+	for _, key := range allKeys {
+		if key.GetType() != storage.KeyTypeEd25519 || key.GetState() == storage.KeyStateRetired || key.Ed25519 == nil {
+			continue
+		}
+		publicKeys[key.ID] = ed25519.PublicKey(key.Ed25519.Public)
+	}
+	return publicKeys, nil
+}
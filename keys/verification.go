@@ -0,0 +1,72 @@
+package keys
+
+import (
+	"math/big"
+
+	"github.com/ucaptcha/backend-go/lib"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+const (
+	// exponentCacheSize bounds the number of difficulties cached per key.
+	exponentCacheSize = 16
+	// verificationContextCacheSize bounds the number of keys with cached material.
+	verificationContextCacheSize = 256
+)
+
+// exponentPair holds 2^T reduced modulo p' and q'.
+type exponentPair struct {
+	eP, eQ *big.Int
+}
+
+// VerificationContext holds precomputed material for verifying RSA challenges
+// issued on one key: p' = (p-1)/2, q' = (q-1)/2 and the reduced exponents
+// 2^T mod p', 2^T mod q' of recently seen difficulties.
+type VerificationContext struct {
+	P, Q           *big.Int
+	PPrime, QPrime *big.Int
+	exponents      *lib.LRU[int64, exponentPair]
+}
+
+// newVerificationContext precomputes the verification material of an RSA key.
+func newVerificationContext(key *storage.KeyPair) *VerificationContext {
+	one := big.NewInt(1)
+	pPrime := new(big.Int).Sub(key.Components.P, one)
+	pPrime.Rsh(pPrime, 1)
+	qPrime := new(big.Int).Sub(key.Components.Q, one)
+	qPrime.Rsh(qPrime, 1)
+
+	return &VerificationContext{
+		P:         key.Components.P,
+		Q:         key.Components.Q,
+		PPrime:    pPrime,
+		QPrime:    qPrime,
+		exponents: lib.NewLRU[int64, exponentPair](exponentCacheSize),
+	}
+}
+
+// Exponents returns 2^t mod p' and 2^t mod q', computing them on first use.
+// The returned values are shared and must not be modified.
+func (vc *VerificationContext) Exponents(t int64) (eP, eQ *big.Int) {
+	if pair, ok := vc.exponents.Get(t); ok {
+		return pair.eP, pair.eQ
+	}
+	exp := big.NewInt(t)
+	pair := exponentPair{
+		eP: new(big.Int).Exp(big.NewInt(2), exp, vc.PPrime),
+		eQ: new(big.Int).Exp(big.NewInt(2), exp, vc.QPrime),
+	}
+	vc.exponents.Add(t, pair)
+	return pair.eP, pair.eQ
+}
+
+// VerificationContext returns the cached verification material of an RSA key,
+// building it on first use.
+func (km *KeyManager) VerificationContext(key *storage.KeyPair) *VerificationContext {
+	if vc, ok := km.contexts.Get(key.ID); ok {
+		return vc
+	}
+	vc := newVerificationContext(key)
+	km.contexts.Add(key.ID, vc)
+	return vc
+}
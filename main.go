@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -9,37 +13,101 @@ import (
 	"github.com/ucaptcha/backend-go/config"
 	"github.com/ucaptcha/backend-go/keys"
 	"github.com/ucaptcha/backend-go/server"
+	"github.com/ucaptcha/backend-go/sites"
 	"github.com/ucaptcha/backend-go/storage"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "secret" {
+		if err := runSecret(os.Args[2:]); err != nil {
+			log.Fatalf("Secret command failed: %v", err)
+		}
+		return
+	}
+
 	if err := config.LoadConfig("config.yaml"); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
 	keyPoolSize := config.GlobalConfig.KeyPoolSize
+	// Startup and rotation are not tied to a request; each storage operation
+	// is still bounded by the storage timeout
+	ctx := context.Background()
 
 	// Initialize storage based on config
-	var keyStorage storage.KeyStorage
-	var challengeStorage storage.ChallengeStorage
+	backends := storage.NewBackends(config.GlobalConfig)
+	defer backends.Close()
 
-	if config.GlobalConfig.KeysStorage == "redis" {
-		keyStorage = storage.NewRedisKeyStorage(config.GlobalConfig.Redis)
-	} else {
-		keyStorage = storage.NewMemoryKeyStorage()
+	keyStorage, err := backends.KeyStorage(ctx, config.GlobalConfig.KeysStorage)
+	if err != nil {
+		log.Fatalf("Failed to initialize key storage: %v", err)
 	}
-	if config.GlobalConfig.ChallengeStorage == "redis" {
-		challengeStorage = storage.NewRedisChallengeStorage(config.GlobalConfig.Redis)
-	} else {
-		challengeStorage = storage.NewMemoryChallengeStorage()
+	if enc := config.GlobalConfig.KeyEncryption; enc.MasterKeyFile != "" || enc.MasterKeyEnv != "" {
+		current, previous, err := loadMasterKeys(enc)
+		if err != nil {
+			log.Fatalf("Failed to load master keys: %v", err)
+		}
+		encrypted := storage.NewEncryptedKeyStorage(keyStorage, current, previous...)
+		// Seal keys stored in plaintext or under a previous master key
+		count, err := encrypted.ReEncrypt(ctx)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt keys: %v", err)
+		}
+		if count > 0 {
+			log.Printf("Re-encrypted %d keys with master key %s", count, current.ID)
+		}
+		keyStorage = encrypted
 	}
-
-	keyManager := keys.NewKeyManager(keyStorage, config.GlobalConfig.KeyLength)
+	challengeStorage, err := backends.ChallengeStorage(ctx, config.GlobalConfig.ChallengeStorage)
+	if err != nil {
+		log.Fatalf("Failed to initialize challenge storage: %v", err)
+	}
+	// Sites and their secrets are long-lived like keys, so they share the key backend
+	if backend := config.GlobalConfig.KeysStorage; backend == storage.BackendMemory || backend == "" {
+		log.Printf("WARNING: key_storage is memory, so sites and site secrets created through /admin are lost when the server stops; use redis, sql or bolt to keep them")
+	}
+	siteStorage, err := backends.SiteStorage(ctx, config.GlobalConfig.KeysStorage)
+	if err != nil {
+		log.Fatalf("Failed to initialize site storage: %v", err)
+	}
+	secretStorage, err := backends.SecretStorage(ctx, config.GlobalConfig.KeysStorage)
+	if err != nil {
+		log.Fatalf("Failed to initialize secret storage: %v", err)
+	}
+	keyStorage = storage.NewTimeoutKeyStorage(keyStorage, config.GlobalConfig.StorageTimeout)
+	challengeStorage = storage.NewTimeoutChallengeStorage(challengeStorage, config.GlobalConfig.StorageTimeout)
+	siteStorage = storage.NewTimeoutSiteStorage(siteStorage, config.GlobalConfig.StorageTimeout)
+	secretStorage = storage.NewTimeoutSecretStorage(secretStorage, config.GlobalConfig.StorageTimeout)
+	defer challengeStorage.Close()
+
+	keyManager := keys.NewKeyManager(keyStorage, keys.Options{
+		KeyLength:        config.GlobalConfig.KeyLength,
+		PrimeMode:        config.GlobalConfig.KeyPrimeMode,
+		DiscriminantBits: config.GlobalConfig.DiscriminantBits,
+		PregenBuffer:     config.GlobalConfig.KeyPregenBuffer,
+		PregenWorkers:    config.GlobalConfig.KeyPregenWorkers,
+	})
+	defer keyManager.Close()
 
 	// Initialize challenge package
 	challenge.InitializeStorage(challengeStorage, keyManager)
+	if cfg := config.GlobalConfig.AdaptiveDifficulty; cfg.Enabled {
+		controller, err := challenge.NewDifficultyController(cfg, config.GlobalConfig.Difficulty)
+		if err != nil {
+			log.Fatalf("Failed to set up adaptive difficulty: %v", err)
+		}
+		challenge.SetDifficultyController(controller)
+		go controller.Run(ctx)
+	}
+	sites.InitializeStorage(siteStorage, secretStorage)
 
-	currentKeyCount, err := keyStorage.GetKeyCount()
+	currentKeyCount, err := keyManager.ActiveKeyCount(ctx, storage.KeyTypeRSA)
 	if err != nil {
 		log.Fatalf("Failed to get key count: %v", err)
 	}
@@ -47,7 +115,7 @@ func main() {
 	// Generate initial keys if needed
 	if currentKeyCount < keyPoolSize {
 		for range keyPoolSize - currentKeyCount {
-			_, err := keyManager.AddKey()
+			_, err := keyManager.AddKey(ctx)
 			if err != nil {
 				log.Fatalf("Failed to generate initial key: %v", err)
 			}
@@ -55,53 +123,92 @@ func main() {
 		log.Printf("Generated %d initial keys", keyPoolSize-currentKeyCount)
 	}
 
-	currentKeyCount, err = keyStorage.GetKeyCount()
+	currentKeyCount, err = keyManager.ActiveKeyCount(ctx, storage.KeyTypeRSA)
 	if err != nil {
 		log.Fatalf("Failed to get key count: %v", err)
 	}
 
 	log.Printf("Current key pool size: %d", currentKeyCount)
 
+	// Keys must keep verifying for as long as challenges issued on them, and
+	// pass tokens signed with them, live
+	gracePeriod := config.GlobalConfig.KeyGracePeriod
+	if ttl := max(challenge.TTL(), challenge.PassTokenTTL()); gracePeriod < ttl {
+		log.Printf("Key grace period %v is shorter than the challenge and pass token lifetimes, using %v", gracePeriod, ttl)
+		gracePeriod = ttl
+	}
+
 	go func() {
 		interval := config.GlobalConfig.KeyRotationInterval
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		for range ticker.C {
-			log.Println("Generating a new RSA key...")
-			allKeys, err := keyStorage.GetAllKeys()
-			if err != nil {
-				log.Printf("Failed to get all keys: %v", err)
-				continue
+			log.Println("Rotating keys...")
+			// Sites may issue challenges that outlive the global TTL
+			grace := gracePeriod
+			if ttl, err := sites.MaxChallengeTTL(ctx); err != nil {
+				log.Printf("Failed to read site challenge TTLs: %v", err)
+			} else {
+				grace = max(grace, ttl)
 			}
-
-			// Add new key
-			_, err = keyManager.AddKey()
-			if err != nil {
-				log.Printf("Failed to generate new key: %v", err)
+			if err := keyManager.Rotate(ctx, grace); err != nil {
+				log.Printf("Failed to rotate keys: %v", err)
 				continue
 			}
-			log.Println("RSA key generated.")
-
-			// Remove oldest key if we have more than one key
-			if len(allKeys) > 0 {
-				oldestKey := allKeys[0]
-				for _, key := range allKeys[1:] {
-					if key.GeneratedAt.Before(oldestKey.GeneratedAt) {
-						oldestKey = key
-					}
-				}
-				if err := keyManager.RemoveKey(oldestKey.ID); err != nil {
-					log.Printf("Failed to remove old key: %v", err)
-				} else {
-					log.Println("Removed old key.")
-				}
-			}
+			log.Println("Keys rotated.")
 		}
 	}()
 
-	router := server.SetupRouter()
-	if err := router.Run(config.GlobalConfig.Host + ":" + strconv.Itoa(config.GlobalConfig.Port)); err != nil {
+	auth, err := server.NewAuthFromConfig(config.GlobalConfig.Auth, config.GlobalConfig.TLS)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %v", err)
+	}
+	if !auth.Enabled() {
+		log.Println("WARNING: authentication is disabled, the API is open to anyone who can reach it")
+	}
+
+	router := server.SetupRouter(auth)
+	addr := config.GlobalConfig.Host + ":" + strconv.Itoa(config.GlobalConfig.Port)
+	tlsCfg := config.GlobalConfig.TLS
+	if tlsCfg.CertFile == "" {
+		err = router.Run(addr)
+	} else {
+		var tlsConfig *tls.Config
+		tlsConfig, err = server.NewTLSConfig(tlsCfg)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		srv := &http.Server{Addr: addr, Handler: router, TLSConfig: tlsConfig}
+		log.Printf("Listening and serving HTTPS on %s", addr)
+		err = srv.ListenAndServeTLS(tlsCfg.CertFile, tlsCfg.KeyFile)
+	}
+	if err != nil {
 		log.Fatalf("Failed to run server: %v", err)
 	}
 }
+
+// loadMasterKeys loads the current master key, from a file or an environment
+// variable, and the previous master keys still needed to open stored keys.
+func loadMasterKeys(cfg config.KeyEncryptionConfig) (*storage.MasterKey, []*storage.MasterKey, error) {
+	var current *storage.MasterKey
+	var err error
+	if cfg.MasterKeyFile != "" {
+		current, err = storage.LoadMasterKeyFile(cfg.MasterKeyFile)
+	} else {
+		current, err = storage.LoadMasterKeyEnv(cfg.MasterKeyEnv)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := make([]*storage.MasterKey, 0, len(cfg.PreviousMasterKeyFiles))
+	for _, path := range cfg.PreviousMasterKeyFiles {
+		mk, err := storage.LoadMasterKeyFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, mk)
+	}
+	return current, previous, nil
+}
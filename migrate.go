@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// runMigrate implements the migrate subcommand, which copies keys, sites and
+// their secrets, and optionally challenges, from one storage backend to another.
+//
+// Keys are copied as stored: keys sealed by key encryption stay sealed, so
+// the server using the destination needs the same master key. The server
+// should be stopped while migrating, or challenges issued meanwhile are lost.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "backend to copy from (redis, sql or bolt)")
+	to := flags.String("to", "", "backend to copy to (redis, sql or bolt)")
+	withChallenges := flags.Bool("challenges", false, "also copy challenges that have not expired")
+	dryRun := flags.Bool("dry-run", false, "report what would be copied without writing anything")
+	configPath := flags.String("config", "config.yaml", "configuration file holding the backend settings")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		flags.Usage()
+		return fmt.Errorf("both --from and --to are required")
+	}
+	if *from == *to {
+		return fmt.Errorf("--from and --to must be different backends")
+	}
+	for _, backend := range []string{*from, *to} {
+		if backend == storage.BackendMemory {
+			return fmt.Errorf("memory storage only lives inside the server process and cannot be migrated")
+		}
+	}
+	if err := config.LoadConfig(*configPath); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	ctx := context.Background()
+	backends := storage.NewBackends(config.GlobalConfig)
+	defer backends.Close()
+
+	if err := migrateKeys(ctx, backends, *from, *to, *dryRun); err != nil {
+		return err
+	}
+	if err := migrateSites(ctx, backends, *from, *to, *dryRun); err != nil {
+		return err
+	}
+	if err := migrateSecrets(ctx, backends, *from, *to, *dryRun); err != nil {
+		return err
+	}
+	if *withChallenges {
+		if err := migrateChallenges(ctx, backends, *from, *to, *dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateKeys copies all keys and checks that each of them reached the destination.
+func migrateKeys(ctx context.Context, backends *storage.Backends, from, to string, dryRun bool) error {
+	src, err := backends.KeyStorage(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to open source key storage: %v", err)
+	}
+	dst, err := backends.KeyStorage(ctx, to)
+	if err != nil {
+		return fmt.Errorf("failed to open destination key storage: %v", err)
+	}
+
+	keyList, err := src.GetAllKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %v", err)
+	}
+	if dryRun {
+		counts := make(map[string]int)
+		for _, key := range keyList {
+			counts[fmt.Sprintf("%s %s", key.GetType(), key.GetState())]++
+		}
+		log.Printf("Would copy %d keys from %s to %s: %v", len(keyList), from, to, counts)
+		return nil
+	}
+
+	for _, key := range keyList {
+		if err := dst.SaveKey(ctx, key); err != nil {
+			return fmt.Errorf("failed to copy key %s: %v", key.ID, err)
+		}
+	}
+
+	copied, err := dst.GetAllKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read copied keys: %v", err)
+	}
+	present := make(map[string]bool, len(copied))
+	for _, key := range copied {
+		present[key.ID] = true
+	}
+	for _, key := range keyList {
+		if !present[key.ID] {
+			return fmt.Errorf("key %s is missing from %s after copying", key.ID, to)
+		}
+	}
+	log.Printf("Copied %d keys from %s to %s (%d keys in %s)", len(keyList), from, to, len(copied), to)
+	return nil
+}
+
+// migrateSites copies all sites and checks that each of them reached the
+// destination.
+func migrateSites(ctx context.Context, backends *storage.Backends, from, to string, dryRun bool) error {
+	src, err := backends.SiteStorage(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to open source site storage: %v", err)
+	}
+	dst, err := backends.SiteStorage(ctx, to)
+	if err != nil {
+		return fmt.Errorf("failed to open destination site storage: %v", err)
+	}
+
+	siteList, err := src.GetAllSites(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read sites: %v", err)
+	}
+	if dryRun {
+		log.Printf("Would copy %d sites from %s to %s", len(siteList), from, to)
+		return nil
+	}
+
+	for _, site := range siteList {
+		if err := dst.SaveSite(ctx, site); err != nil {
+			return fmt.Errorf("failed to copy site %s: %v", site.Key, err)
+		}
+	}
+	for _, site := range siteList {
+		if _, err := dst.GetSite(ctx, site.Key); err != nil {
+			return fmt.Errorf("site %s is missing from %s after copying: %v", site.Key, to, err)
+		}
+	}
+	log.Printf("Copied %d sites from %s to %s", len(siteList), from, to)
+	return nil
+}
+
+// migrateSecrets copies all site secrets and checks that each of them
+// reached the destination.
+func migrateSecrets(ctx context.Context, backends *storage.Backends, from, to string, dryRun bool) error {
+	src, err := backends.SecretStorage(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to open source secret storage: %v", err)
+	}
+	dst, err := backends.SecretStorage(ctx, to)
+	if err != nil {
+		return fmt.Errorf("failed to open destination secret storage: %v", err)
+	}
+
+	secrets, err := src.GetAllSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets: %v", err)
+	}
+	if dryRun {
+		log.Printf("Would copy %d site secrets from %s to %s", len(secrets), from, to)
+		return nil
+	}
+
+	for _, secret := range secrets {
+		if err := dst.SaveSecret(ctx, secret); err != nil {
+			return fmt.Errorf("failed to copy secret of site %s: %v", secret.Site, err)
+		}
+	}
+	for _, secret := range secrets {
+		if _, err := dst.GetSecret(ctx, secret.Hash); err != nil {
+			return fmt.Errorf("secret of site %s is missing from %s after copying: %v", secret.Site, to, err)
+		}
+	}
+	log.Printf("Copied %d site secrets from %s to %s", len(secrets), from, to)
+	return nil
+}
+
+// migrateChallenges copies all challenges that are not past retention and
+// checks that each of them reached the destination. Challenges dropped
+// while copying are not counted as missing.
+func migrateChallenges(ctx context.Context, backends *storage.Backends, from, to string, dryRun bool) error {
+	src, err := backends.ChallengeStorage(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to open source challenge storage: %v", err)
+	}
+	defer src.Close()
+	dst, err := backends.ChallengeStorage(ctx, to)
+	if err != nil {
+		return fmt.Errorf("failed to open destination challenge storage: %v", err)
+	}
+	defer dst.Close()
+
+	challenges, err := src.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read challenges: %v", err)
+	}
+	if dryRun {
+		log.Printf("Would copy %d challenges from %s to %s", len(challenges), from, to)
+		return nil
+	}
+
+	for _, ch := range challenges {
+		if err := dst.Save(ctx, ch); err != nil {
+			return fmt.Errorf("failed to copy challenge %s: %v", ch.ID, err)
+		}
+	}
+
+	copied, err := dst.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read copied challenges: %v", err)
+	}
+	present := make(map[string]bool, len(copied))
+	for _, ch := range copied {
+		present[ch.ID] = true
+	}
+	now := time.Now()
+	for _, ch := range challenges {
+		if !present[ch.ID] && now.Before(storage.RetainUntil(ch)) {
+			return fmt.Errorf("challenge %s is missing from %s after copying", ch.ID, to)
+		}
+	}
+	log.Printf("Copied %d challenges from %s to %s", len(challenges), from, to)
+	return nil
+}
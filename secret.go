@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/sites"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// secretUsage describes the secret subcommand.
+const secretUsage = `usage: ucaptcha secret <command> [flags]
+
+Commands:
+  create --site <key>  create a secret for a site and print it
+  list                 list the sites holding secrets
+  delete --site <key>  delete every secret of a site
+
+Sites are created through the admin API, POST /admin/sites.`
+
+// runSecret implements the secret subcommand, which manages the site
+// secrets used by POST /siteverify. Secrets are stored on the key storage
+// backend, which must persist outside the server process.
+func runSecret(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, secretUsage)
+		return fmt.Errorf("missing command")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("secret "+command, flag.ExitOnError)
+	site := flags.String("site", "", "key of the site the secret belongs to")
+	configPath := flags.String("config", "config.yaml", "configuration file holding the backend settings")
+	flags.Parse(args[1:])
+
+	if err := config.LoadConfig(*configPath); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	backend := config.GlobalConfig.KeysStorage
+	if backend == storage.BackendMemory || backend == "" {
+		return fmt.Errorf("sites are stored with keys, and memory key storage only lives inside the server process")
+	}
+
+	ctx := context.Background()
+	backends := storage.NewBackends(config.GlobalConfig)
+	defer backends.Close()
+	siteStorage, err := backends.SiteStorage(ctx, backend)
+	if err != nil {
+		return fmt.Errorf("failed to open site storage: %v", err)
+	}
+	secretStorage, err := backends.SecretStorage(ctx, backend)
+	if err != nil {
+		return fmt.Errorf("failed to open secret storage: %v", err)
+	}
+	timeout := config.GlobalConfig.StorageTimeout
+	manager := sites.NewManager(storage.NewTimeoutSiteStorage(siteStorage, timeout), storage.NewTimeoutSecretStorage(secretStorage, timeout))
+
+	switch command {
+	case "create":
+		if *site == "" {
+			return fmt.Errorf("--site is required")
+		}
+		secret, _, err := manager.CreateSecret(ctx, *site)
+		if err != nil {
+			return err
+		}
+		// The secret cannot be recovered later, only its hash is stored
+		fmt.Println(secret)
+	case "list":
+		secrets, err := manager.Secrets(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read secrets: %v", err)
+		}
+		sort.Slice(secrets, func(i, j int) bool {
+			if secrets[i].Site != secrets[j].Site {
+				return secrets[i].Site < secrets[j].Site
+			}
+			return secrets[i].CreatedAt.Before(secrets[j].CreatedAt)
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SITE\tCREATED\tHASH")
+		for _, secret := range secrets {
+			fmt.Fprintf(w, "%s\t%s\t%.12s\n", secret.Site, secret.CreatedAt.UTC().Format(time.RFC3339), secret.Hash)
+		}
+		w.Flush()
+	case "delete":
+		if *site == "" {
+			return fmt.Errorf("--site is required")
+		}
+		deleted, err := manager.DeleteSecrets(ctx, *site)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d secrets of %s\n", deleted, *site)
+	default:
+		fmt.Fprintln(os.Stderr, secretUsage)
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
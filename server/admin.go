@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/sites"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+type SiteRequest struct {
+	Name         string   `json:"name,omitempty"`
+	Hostnames    []string `json:"hostnames,omitempty"`
+	Difficulty   int64    `json:"difficulty,omitempty"`
+	ChallengeTTL string   `json:"challenge_ttl,omitempty"` // Duration such as "2m"
+}
+
+type SiteResponse struct {
+	Key          string   `json:"key"`
+	Name         string   `json:"name,omitempty"`
+	Hostnames    []string `json:"hostnames"`
+	Difficulty   int64    `json:"difficulty,omitempty"`
+	ChallengeTTL string   `json:"challenge_ttl,omitempty"`
+	Disabled     bool     `json:"disabled"`
+	CreatedAt    string   `json:"created_at"`
+}
+
+func newSiteResponse(site *storage.Site) SiteResponse {
+	resp := SiteResponse{
+		Key:        site.Key,
+		Name:       site.Name,
+		Hostnames:  site.Hostnames,
+		Difficulty: site.Difficulty,
+		Disabled:   site.Disabled,
+		CreatedAt:  site.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if resp.Hostnames == nil {
+		resp.Hostnames = []string{}
+	}
+	if site.ChallengeTTL > 0 {
+		resp.ChallengeTTL = site.ChallengeTTL.String()
+	}
+	return resp
+}
+
+// createSiteHandler creates a site and returns it with its first secret,
+// which cannot be retrieved later.
+func createSiteHandler(c *gin.Context) {
+	var req SiteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
+		return
+	}
+	opts := sites.SiteOptions{
+		Name:       req.Name,
+		Hostnames:  req.Hostnames,
+		Difficulty: req.Difficulty,
+	}
+	if req.ChallengeTTL != "" {
+		ttl, err := time.ParseDuration(req.ChallengeTTL)
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid challenge_ttl")
+			return
+		}
+		opts.ChallengeTTL = ttl
+	}
+	if err := opts.Validate(); err != nil {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	site, secret, err := sites.CreateSite(c.Request.Context(), opts)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"success": true, "site": newSiteResponse(site), "secret": secret})
+}
+
+// listSitesHandler lists all sites, oldest first.
+func listSitesHandler(c *gin.Context) {
+	all, err := sites.Sites(c.Request.Context())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.Before(all[j].CreatedAt) })
+	resp := make([]SiteResponse, 0, len(all))
+	for _, site := range all {
+		resp = append(resp, newSiteResponse(site))
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "sites": resp})
+}
+
+func getSiteHandler(c *gin.Context) {
+	site, err := sites.Site(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		siteError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "site": newSiteResponse(site)})
+}
+
+// rotateSecretHandler replaces the secrets of a site with a new one.
+func rotateSecretHandler(c *gin.Context) {
+	secret, err := sites.RotateSecret(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		siteError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "secret": secret})
+}
+
+func disableSiteHandler(c *gin.Context) {
+	setSiteDisabled(c, true)
+}
+
+func enableSiteHandler(c *gin.Context) {
+	setSiteDisabled(c, false)
+}
+
+func setSiteDisabled(c *gin.Context, disabled bool) {
+	site, err := sites.SetDisabled(c.Request.Context(), c.Param("key"), disabled)
+	if err != nil {
+		siteError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "site": newSiteResponse(site)})
+}
+
+// siteError writes the response to a failed operation on a site.
+func siteError(c *gin.Context, err error) {
+	if errors.Is(err, sites.ErrSiteNotFound) {
+		errorResponse(c, http.StatusNotFound, CodeSiteNotFound, "Site not found")
+		return
+	}
+	internalError(c, err)
+}
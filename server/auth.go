@@ -0,0 +1,209 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// Scope is a permission granted to an API client.
+type Scope string
+
+const (
+	ScopeIssue  Scope = "issue"  // Create challenges
+	ScopeVerify Scope = "verify" // Verify answers and pass tokens
+	ScopeAdmin  Scope = "admin"  // Read and change the default difficulty, and manage sites
+)
+
+// identityKey is the gin context key the authenticated Identity is stored under.
+const identityKey = "ucaptcha.identity"
+
+// Identity is an authenticated API client.
+type Identity struct {
+	Name   string  // Name of the API key, HMAC key ID or certificate common name
+	Method string  // Authentication method: "api_key", "hmac" or "mtls"
+	Scopes []Scope // Scopes granted to the client
+}
+
+// HasScope reports whether the client was granted scope.
+func (id *Identity) HasScope(scope Scope) bool {
+	return slices.Contains(id.Scopes, scope)
+}
+
+// Authenticator identifies the client of a request from one kind of
+// credentials. It returns nil and no error if the request carries no
+// credentials of its kind, so that the next authenticator is tried.
+type Authenticator interface {
+	Authenticate(c *gin.Context) (*Identity, error)
+}
+
+// Auth authenticates requests with a list of authenticators, tried in
+// order, and checks the scopes of the client.
+type Auth struct {
+	authenticators []Authenticator
+}
+
+// NewAuth creates an Auth instance from the given authenticators. Without
+// authenticators, every request is let through.
+func NewAuth(authenticators ...Authenticator) *Auth {
+	return &Auth{authenticators: authenticators}
+}
+
+// NewAuthFromConfig creates an Auth instance with the API keys, HMAC keys
+// and client certificates of cfg, tried in that order. Without credentials,
+// it fails unless authentication is explicitly disabled, so that the admin
+// routes are not left open by mistake.
+func NewAuthFromConfig(cfg config.AuthConfig, tlsCfg config.TLSConfig) (*Auth, error) {
+	// Client certificates are only requested over HTTPS
+	if tlsCfg.ClientCAFile != "" && tlsCfg.CertFile == "" {
+		return nil, fmt.Errorf("tls.client_ca_file requires tls.cert_file")
+	}
+
+	var authenticators []Authenticator
+	if len(cfg.APIKeys) > 0 {
+		apiKeys, err := NewAPIKeyAuthenticator(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, apiKeys)
+	}
+	if len(cfg.HMAC.Keys) > 0 {
+		hmacKeys, err := NewHMACAuthenticator(cfg.HMAC)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, hmacKeys)
+	}
+	if len(cfg.MTLS.Clients) > 0 {
+		if tlsCfg.ClientCAFile == "" {
+			return nil, fmt.Errorf("mTLS clients require tls.cert_file and tls.client_ca_file")
+		}
+		clients, err := NewMTLSAuthenticator(cfg.MTLS.Clients)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, clients)
+	}
+	switch {
+	case cfg.Disabled && len(authenticators) > 0:
+		return nil, fmt.Errorf("auth.disabled cannot be set along with credentials")
+	case !cfg.Disabled && len(authenticators) == 0:
+		return nil, fmt.Errorf("no API credentials are configured, set auth.disabled to run without authentication")
+	}
+	return NewAuth(authenticators...), nil
+}
+
+// Enabled reports whether requests are authenticated at all.
+func (a *Auth) Enabled() bool {
+	return len(a.authenticators) > 0
+}
+
+// Require returns a middleware refusing requests from clients that are not
+// authenticated, with 401, or that lack scope, with 403.
+func (a *Auth) Require(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		id, err := a.authenticate(c)
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			errorResponse(c, http.StatusUnauthorized, CodeUnauthorized, "Authentication failed: "+err.Error())
+			c.Abort()
+			return
+		}
+		if id == nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			errorResponse(c, http.StatusUnauthorized, CodeUnauthorized, "Authentication required")
+			c.Abort()
+			return
+		}
+		if !id.HasScope(scope) {
+			errorResponse(c, http.StatusForbidden, CodeInsufficientScope, fmt.Sprintf("The %s scope is required", scope))
+			c.Abort()
+			return
+		}
+		c.Set(identityKey, id)
+		c.Next()
+	}
+}
+
+// authenticate returns the identity found by the first authenticator the
+// request carries credentials for, or nil if it carries none.
+func (a *Auth) authenticate(c *gin.Context) (*Identity, error) {
+	for _, authenticator := range a.authenticators {
+		id, err := authenticator.Authenticate(c)
+		if err != nil || id != nil {
+			return id, err
+		}
+	}
+	return nil, nil
+}
+
+// clientName returns the name of the authenticated client of a request,
+// for logging.
+func clientName(c *gin.Context) string {
+	if id, ok := c.Get(identityKey); ok {
+		return id.(*Identity).Name
+	}
+	return "an unauthenticated client"
+}
+
+// parseScopes checks the scope names of a configured client.
+func parseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		switch scope := Scope(name); scope {
+		case ScopeIssue, ScopeVerify, ScopeAdmin:
+			scopes = append(scopes, scope)
+		default:
+			return nil, fmt.Errorf("unknown scope: %q", name)
+		}
+	}
+	return scopes, nil
+}
+
+// APIKeyAuthenticator authenticates requests with static bearer keys sent
+// as "Authorization: Bearer <key>".
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]*Identity // Keyed by the hash of the key, so lookups take constant time
+}
+
+// NewAPIKeyAuthenticator creates an APIKeyAuthenticator for the configured keys.
+func NewAPIKeyAuthenticator(keys []config.APIKeyConfig) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{keys: make(map[[sha256.Size]byte]*Identity, len(keys))}
+	for _, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("API keys need a name and a key")
+		}
+		scopes, err := parseScopes(key.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("API key %s: %v", key.Name, err)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := a.keys[hash]; ok {
+			return nil, fmt.Errorf("API key %s is configured twice", key.Name)
+		}
+		a.keys[hash] = &Identity{Name: key.Name, Method: "api_key", Scopes: scopes}
+	}
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(c *gin.Context) (*Identity, error) {
+	header := c.GetHeader("Authorization")
+	scheme, key, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+	id, ok := a.keys[sha256.Sum256([]byte(strings.TrimSpace(key)))]
+	if !ok {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	return id, nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/challenge"
+)
+
+type DifficultyAdjustmentResponse struct {
+	Time   string `json:"time"`
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Reason string `json:"reason"`
+}
+
+type DifficultyStatusResponse struct {
+	Success       bool                           `json:"success"`
+	Adaptive      bool                           `json:"adaptive"`
+	Difficulty    int64                          `json:"difficulty"`
+	MinDifficulty int64                          `json:"min_difficulty,omitempty"`
+	MaxDifficulty int64                          `json:"max_difficulty,omitempty"`
+	Window        string                         `json:"window,omitempty"`
+	Issued        int64                          `json:"issued"`
+	Solved        int64                          `json:"solved"`
+	Failed        int64                          `json:"failed"`
+	IssueRate     float64                        `json:"issue_rate"`
+	VerifyRate    float64                        `json:"verify_rate"`
+	FailureRatio  float64                        `json:"failure_ratio"`
+	Adjustments   []DifficultyAdjustmentResponse `json:"adjustments"`
+}
+
+// difficultyStatusHandler reports the default difficulty and, with adaptive
+// difficulty, the load it is adjusted to and its recent adjustments.
+func difficultyStatusHandler(c *gin.Context) {
+	status := challenge.Difficulty()
+	resp := DifficultyStatusResponse{
+		Success:       true,
+		Adaptive:      status.Adaptive,
+		Difficulty:    status.Difficulty,
+		MinDifficulty: status.MinDifficulty,
+		MaxDifficulty: status.MaxDifficulty,
+		Issued:        status.Issued,
+		Solved:        status.Solved,
+		Failed:        status.Failed,
+		IssueRate:     status.IssueRate,
+		VerifyRate:    status.VerifyRate,
+		FailureRatio:  status.FailureRatio,
+		Adjustments:   make([]DifficultyAdjustmentResponse, 0, len(status.Adjustments)),
+	}
+	if status.Window > 0 {
+		resp.Window = status.Window.String()
+	}
+	for _, adjustment := range status.Adjustments {
+		resp.Adjustments = append(resp.Adjustments, DifficultyAdjustmentResponse{
+			Time:   adjustment.Time.UTC().Format(time.RFC3339),
+			From:   adjustment.From,
+			To:     adjustment.To,
+			Reason: adjustment.Reason,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
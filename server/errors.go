@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/challenge"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// Error codes of JSON error responses. Unlike error messages, they are
+// stable and meant to be matched by programs.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidSolution    = "invalid_solution"
+	CodeMalformedSolution  = "malformed_solution"
+	CodeNotFound           = "challenge_not_found"
+	CodeAlreadyUsed        = "challenge_already_used"
+	CodeExpired            = "challenge_expired"
+	CodeKeyUnavailable     = "key_unavailable"
+	CodeInvalidToken       = "invalid_token"
+	CodeTokenExpired       = "token_expired"
+	CodeInvalidSiteKey     = "invalid_site_key"
+	CodeSiteDisabled       = "site_disabled"
+	CodeHostnameNotAllowed = "hostname_not_allowed"
+	CodeSiteNotFound       = "site_not_found"
+	CodeUnauthorized       = "unauthorized"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeStorageUnavailable = "storage_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// verifyErrors maps the verification results other than ResultValid to
+// their HTTP status and error code.
+var verifyErrors = map[challenge.VerifyResult]struct {
+	status int
+	code   string
+}{
+	challenge.ResultInvalid:        {http.StatusUnauthorized, CodeInvalidSolution},
+	challenge.ResultNotFound:       {http.StatusNotFound, CodeNotFound},
+	challenge.ResultAlreadyUsed:    {http.StatusNotFound, CodeAlreadyUsed},
+	challenge.ResultMalformed:      {http.StatusBadRequest, CodeMalformedSolution},
+	challenge.ResultExpired:        {http.StatusGone, CodeExpired},
+	challenge.ResultKeyUnavailable: {http.StatusInternalServerError, CodeKeyUnavailable},
+	challenge.ResultUnavailable:    {http.StatusServiceUnavailable, CodeStorageUnavailable},
+}
+
+// errorResponse writes a JSON error response.
+func errorResponse(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"success": false, "error": message, "error_code": code})
+}
+
+// internalError writes the response to an unexpected error. Storage
+// timeouts are reported as 503 so that clients can retry, anything else as
+// 500. The error itself is logged rather than returned, as it may reveal
+// internals.
+func internalError(c *gin.Context, err error) {
+	log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	if errors.Is(err, storage.ErrTimeout) {
+		errorResponse(c, http.StatusServiceUnavailable, CodeStorageUnavailable, "Storage unavailable")
+		return
+	}
+	errorResponse(c, http.StatusInternalServerError, CodeInternal, "Internal error")
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// Headers of HMAC-signed requests.
+const (
+	HeaderKeyID     = "X-UCaptcha-Key-ID"
+	HeaderTimestamp = "X-UCaptcha-Timestamp"
+	HeaderSignature = "X-UCaptcha-Signature"
+)
+
+const (
+	defaultHMACWindow = 5 * time.Minute
+	maxSignedBodySize = 1 << 20 // Largest request body hashed for a signature
+)
+
+// hmacKey is a configured HMAC key along with the client it identifies.
+type hmacKey struct {
+	secret   []byte
+	identity *Identity
+}
+
+// HMACAuthenticator authenticates requests signed with a shared secret.
+//
+// The signature is the hex-encoded HMAC-SHA256 of the timestamp (Unix
+// seconds), the method, the request URI and the hex-encoded SHA-256 of the
+// body, joined by newlines. Requests whose timestamp is further than the
+// window from the server clock are refused, and so are signatures already
+// seen within the window. Seen signatures are only remembered by this
+// server, so a request may be replayed once against each other server.
+type HMACAuthenticator struct {
+	keys   map[string]hmacKey
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // Signatures mapped to the time they can be forgotten
+	lastPrune time.Time
+}
+
+// NewHMACAuthenticator creates an HMACAuthenticator for the configured keys.
+func NewHMACAuthenticator(cfg config.HMACAuthConfig) (*HMACAuthenticator, error) {
+	a := &HMACAuthenticator{
+		keys:   make(map[string]hmacKey, len(cfg.Keys)),
+		window: cfg.Window,
+		seen:   make(map[string]time.Time),
+	}
+	if a.window <= 0 {
+		a.window = defaultHMACWindow
+	}
+	for _, key := range cfg.Keys {
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("HMAC keys need an ID and a secret")
+		}
+		if _, ok := a.keys[key.ID]; ok {
+			return nil, fmt.Errorf("HMAC key %s is configured twice", key.ID)
+		}
+		scopes, err := parseScopes(key.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("HMAC key %s: %v", key.ID, err)
+		}
+		a.keys[key.ID] = hmacKey{
+			secret:   []byte(key.Secret),
+			identity: &Identity{Name: key.ID, Method: "hmac", Scopes: scopes},
+		}
+	}
+	return a, nil
+}
+
+// SignRequest returns the signature of a request, as sent in the
+// X-UCaptcha-Signature header.
+func SignRequest(secret []byte, timestamp int64, method, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s", timestamp, method, requestURI, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *HMACAuthenticator) Authenticate(c *gin.Context) (*Identity, error) {
+	keyID := c.GetHeader(HeaderKeyID)
+	if keyID == "" {
+		return nil, nil
+	}
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown HMAC key")
+	}
+
+	timestamp, err := strconv.ParseInt(c.GetHeader(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header", HeaderTimestamp)
+	}
+	now := time.Now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-a.window)) || signedAt.After(now.Add(a.window)) {
+		return nil, fmt.Errorf("request timestamp is outside the replay window")
+	}
+
+	signature, err := hex.DecodeString(c.GetHeader(HeaderSignature))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header", HeaderSignature)
+	}
+	body, err := readBody(c)
+	if err != nil {
+		return nil, err
+	}
+	expected, _ := hex.DecodeString(SignRequest(key.secret, timestamp, c.Request.Method, c.Request.URL.RequestURI(), body))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("invalid request signature")
+	}
+
+	// The signature is only remembered once checked, so that forged
+	// requests cannot fill the replay cache
+	if !a.remember(hex.EncodeToString(signature), signedAt.Add(a.window), now) {
+		return nil, fmt.Errorf("request was already received")
+	}
+	return key.identity, nil
+}
+
+// remember records a signature until forgetAt, reporting false if it was
+// already recorded.
+func (a *HMACAuthenticator) remember(signature string, forgetAt, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.lastPrune) > a.window {
+		for sig, until := range a.seen {
+			if now.After(until) {
+				delete(a.seen, sig)
+			}
+		}
+		a.lastPrune = now
+	}
+	if until, ok := a.seen[signature]; ok && !now.After(until) {
+		return false
+	}
+	a.seen[signature] = forgetAt
+	return true
+}
+
+// readBody reads the request body for hashing and puts it back for the handler.
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body")
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, fmt.Errorf("request body is too large")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// NewTLSConfig returns the TLS configuration of the server. With a client CA
+// file, client certificates signed by it are requested and verified; clients
+// without one can still connect and authenticate otherwise.
+func NewTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// MTLSAuthenticator identifies clients by the common name of the
+// certificate they presented, verified against the client CA during the
+// TLS handshake.
+type MTLSAuthenticator struct {
+	clients map[string]*Identity
+}
+
+// NewMTLSAuthenticator creates an MTLSAuthenticator for the configured clients.
+func NewMTLSAuthenticator(clients []config.MTLSClientConfig) (*MTLSAuthenticator, error) {
+	a := &MTLSAuthenticator{clients: make(map[string]*Identity, len(clients))}
+	for _, client := range clients {
+		if client.CommonName == "" {
+			return nil, fmt.Errorf("mTLS clients need a common name")
+		}
+		if _, ok := a.clients[client.CommonName]; ok {
+			return nil, fmt.Errorf("mTLS client %s is configured twice", client.CommonName)
+		}
+		scopes, err := parseScopes(client.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("mTLS client %s: %v", client.CommonName, err)
+		}
+		a.clients[client.CommonName] = &Identity{Name: client.CommonName, Method: "mtls", Scopes: scopes}
+	}
+	return a, nil
+}
+
+func (a *MTLSAuthenticator) Authenticate(c *gin.Context) (*Identity, error) {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	commonName := state.VerifiedChains[0][0].Subject.CommonName
+	id, ok := a.clients[commonName]
+	if !ok {
+		return nil, fmt.Errorf("client certificate %q is not authorized", commonName)
+	}
+	return id, nil
+}
@@ -1,113 +1,206 @@
 package server
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ucaptcha/backend-go/challenge"
-	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/sites"
+	"github.com/ucaptcha/backend-go/storage"
 	"github.com/ucaptcha/backend-go/types"
 )
 
 type ChallengeResponse struct {
-	Success bool   `json:"success"`
-	ID      string `json:"id"`
-	G       string `json:"g"`
-	N       string `json:"n"`
-	T       int64  `json:"t"`
+	Success      bool     `json:"success"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	Scheme       string   `json:"scheme,omitempty"`
+	G            string   `json:"g,omitempty"`
+	N            string   `json:"n,omitempty"`
+	Prefix       string   `json:"prefix,omitempty"`
+	Salt         string   `json:"salt,omitempty"`
+	Target       string   `json:"target,omitempty"`
+	Argon2       *Argon2  `json:"argon2,omitempty"`
+	T            int64    `json:"t"`
+	ProofSchemes []string `json:"proof_schemes,omitempty"`
+}
+
+type Argon2 struct {
+	Memory    uint32 `json:"memory"`
+	Time      uint32 `json:"time"`
+	Threads   uint8  `json:"threads"`
+	KeyLength uint32 `json:"key_length"`
 }
 
 type VerifyRequest struct {
-	Y string `json:"y"`
+	SiteKey     string   `json:"site_key"`
+	Y           string   `json:"y"`
+	ProofScheme string   `json:"proof_scheme,omitempty"`
+	Proof       string   `json:"proof,omitempty"`
+	Midpoints   []string `json:"midpoints,omitempty"`
+	Nonce       string   `json:"nonce,omitempty"`
 }
 
 type ChallengeRequest struct {
+	SiteKey    string `json:"site_key"`
 	Difficulty *int64 `json:"difficulty,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Scheme     string `json:"scheme,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+	Action     string `json:"action,omitempty"`
 }
 
-func SetupRouter() *gin.Engine {
+// SetupRouter registers the API routes, guarded by auth. Pass tokens are
+// redeemed through siteverify with site secrets, and their public keys are
+// public, so those routes need no API credentials.
+func SetupRouter(auth *Auth) *gin.Engine {
 	r := gin.Default()
 
-	r.POST("/challenge", createChallengeHandler)
-	r.POST("/challenge/:id/validation", verifyChallengeHandler)
-	r.PUT("/difficulty", updateDifficultyHandler)
+	r.POST("/challenge", auth.Require(ScopeIssue), createChallengeHandler)
+	r.POST("/challenge/:id/validation", auth.Require(ScopeVerify), verifyChallengeHandler)
+	r.PUT("/difficulty", auth.Require(ScopeAdmin), updateDifficultyHandler)
+	r.GET("/difficulty", auth.Require(ScopeAdmin), difficultyStatusHandler)
+	r.POST("/token/verify", auth.Require(ScopeVerify), verifyTokenHandler)
+	r.GET("/token/keys", tokenKeysHandler)
+	r.POST("/siteverify", siteverifyHandler)
+
+	admin := r.Group("/admin", auth.Require(ScopeAdmin))
+	admin.POST("/sites", createSiteHandler)
+	admin.GET("/sites", listSitesHandler)
+	admin.GET("/sites/:key", getSiteHandler)
+	admin.POST("/sites/:key/secret", rotateSecretHandler)
+	admin.POST("/sites/:key/disable", disableSiteHandler)
+	admin.POST("/sites/:key/enable", enableSiteHandler)
 
 	return r
 }
 
 func createChallengeHandler(c *gin.Context) {
 	var req ChallengeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		ch, err := challenge.NewChallenge()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusCreated, ChallengeResponse{
-			Success: true,
-			ID:      ch.ID,
-			G:       ch.G.String(),
-			N:       ch.N.String(),
-			T:       ch.T,
-		})
+	if err := c.ShouldBindJSON(&req); err != nil || req.SiteKey == "" {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request, a site_key is required")
+		return
+	}
+	site, ok := lookupSite(c, req.SiteKey)
+	if !ok {
+		return
+	}
+	if !sites.HostnameAllowed(site, req.Hostname) {
+		errorResponse(c, http.StatusForbidden, CodeHostnameNotAllowed, "Hostname not allowed for this site")
 		return
 	}
 
-	var ch *types.Challenge
-	var err error
-
-	if req.Difficulty != nil {
-		ch, err = challenge.NewChallenge(*req.Difficulty)
-	} else {
-		ch, err = challenge.NewChallenge()
+	opts := challenge.ChallengeOptions{
+		Difficulty: req.Difficulty,
+		Type:       req.Type,
+		Scheme:     req.Scheme,
+		Site:       site,
+		Hostname:   req.Hostname,
+		Action:     req.Action,
+	}
+	if err := opts.Validate(); err != nil {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
 	}
 
+	ch, err := challenge.NewChallengeWithOptions(c.Request.Context(), opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		internalError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, ChallengeResponse{
-		Success: true,
-		ID:      ch.ID,
-		G:       ch.G.String(),
-		N:       ch.N.String(),
-		T:       ch.T,
-	})
+	c.JSON(http.StatusCreated, newChallengeResponse(ch))
+}
+
+func newChallengeResponse(ch *types.Challenge) ChallengeResponse {
+	resp := ChallengeResponse{
+		Success:      true,
+		ID:           ch.ID,
+		Type:         ch.Type,
+		Scheme:       ch.Scheme,
+		T:            ch.T,
+		ProofSchemes: challenge.AcceptedProofSchemes(ch),
+	}
+	switch {
+	case ch.Type == types.PuzzleHashcash:
+		resp.Prefix = hex.EncodeToString(ch.Prefix)
+	case ch.Type == types.PuzzleArgon2id:
+		resp.Salt = hex.EncodeToString(ch.Prefix)
+		resp.Target = fmt.Sprintf("%064x", ch.Target)
+		resp.Argon2 = &Argon2{
+			Memory:    ch.Argon2.Memory,
+			Time:      ch.Argon2.Time,
+			Threads:   ch.Argon2.Threads,
+			KeyLength: ch.Argon2.KeyLen,
+		}
+	case ch.Scheme == types.SchemeClassGroup:
+		resp.G = ch.Form.String()
+		resp.N = ch.N.String()
+	default:
+		resp.G = ch.G.String()
+		resp.N = ch.N.String()
+	}
+	return resp
 }
 
 func verifyChallengeHandler(c *gin.Context) {
 	id := c.Param("id")
 
 	var req VerifyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request"})
+	if err := c.ShouldBindJSON(&req); err != nil || req.SiteKey == "" {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request, a site_key is required")
+		return
+	}
+	site, ok := lookupSite(c, req.SiteKey)
+	if !ok {
 		return
 	}
 
-	result, err := challenge.VerifyChallenge(id, req.Y)
-	if err != nil {
-		switch result {
-		case 2:
-			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
-		case 3:
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
-		case 4:
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+	result, passToken, err := challenge.VerifyAndIssueToken(c.Request.Context(), id, site.Key, challenge.Solution{
+		Y:           req.Y,
+		ProofScheme: req.ProofScheme,
+		Proof:       req.Proof,
+		Midpoints:   req.Midpoints,
+		Nonce:       req.Nonce,
+	})
+	if result == challenge.ResultValid {
+		if err != nil {
+			internalError(c, err)
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "token": passToken})
+		return
+	}
+	verifyErr, ok := verifyErrors[result]
+	if !ok {
+		internalError(c, fmt.Errorf("unknown verification result %v: %v", result, err))
 		return
 	}
-	switch result {
-	case 1:
-		c.JSON(http.StatusOK, gin.H{"success": true})
-	case 0:
-		c.JSON(http.StatusUnauthorized, gin.H{"success": false})
+	message := "Incorrect answer"
+	if err != nil {
+		message = err.Error()
+	}
+	errorResponse(c, verifyErr.status, verifyErr.code, message)
+}
+
+// lookupSite returns the enabled site with the given key, or writes the
+// error response and returns false.
+func lookupSite(c *gin.Context, key string) (*storage.Site, bool) {
+	site, err := sites.Lookup(c.Request.Context(), key)
+	switch {
+	case errors.Is(err, sites.ErrSiteNotFound):
+		errorResponse(c, http.StatusForbidden, CodeInvalidSiteKey, "Invalid site key")
+	case errors.Is(err, sites.ErrSiteDisabled):
+		errorResponse(c, http.StatusForbidden, CodeSiteDisabled, "Site is disabled")
+	case err != nil:
+		internalError(c, err)
 	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Unknown error"})
+		return site, true
 	}
+	return nil, false
 }
 
 type DifficultyRequest struct {
@@ -117,10 +210,14 @@ type DifficultyRequest struct {
 func updateDifficultyHandler(c *gin.Context) {
 	var req DifficultyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid request"})
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
+		return
+	}
+	if req.Difficulty < 1 {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Difficulty must be at least 1")
 		return
 	}
 
-	config.GlobalConfig.Difficulty = req.Difficulty
-	c.JSON(http.StatusOK, gin.H{"success": true, "difficulty": req.Difficulty})
+	difficulty := challenge.SetDefaultDifficulty(req.Difficulty, "set by "+clientName(c))
+	c.JSON(http.StatusOK, gin.H{"success": true, "difficulty": difficulty})
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/challenge"
+	"github.com/ucaptcha/backend-go/sites"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/token"
+)
+
+// Error codes of siteverify responses, as defined by reCAPTCHA and hCaptcha.
+const (
+	siteverifyMissingSecret   = "missing-input-secret"
+	siteverifyInvalidSecret   = "invalid-input-secret"
+	siteverifyMissingResponse = "missing-input-response"
+	siteverifyInvalidResponse = "invalid-input-response"
+	siteverifyDuplicate       = "timeout-or-duplicate"
+	siteverifyInternal        = "internal-error"
+)
+
+// SiteverifyResponse is the reply of POST /siteverify, in the format of the
+// reCAPTCHA and hCaptcha siteverify APIs.
+type SiteverifyResponse struct {
+	Success     bool     `json:"success"`
+	ChallengeTS string   `json:"challenge_ts,omitempty"` // Time the challenge was solved, RFC 3339
+	Hostname    string   `json:"hostname,omitempty"`
+	Action      string   `json:"action,omitempty"`
+	ErrorCodes  []string `json:"error-codes,omitempty"`
+}
+
+// siteverifyHandler redeems a pass token on behalf of the site owning the
+// secret. Like reCAPTCHA and hCaptcha, it reads the secret and response
+// form fields and reports rejected tokens with status 200.
+func siteverifyHandler(c *gin.Context) {
+	secret := formValue(c, "secret")
+	response := formValue(c, "response")
+	var missing []string
+	if secret == "" {
+		missing = append(missing, siteverifyMissingSecret)
+	}
+	if response == "" {
+		missing = append(missing, siteverifyMissingResponse)
+	}
+	if len(missing) > 0 {
+		siteverifyFailure(c, missing...)
+		return
+	}
+
+	ctx := c.Request.Context()
+	site, err := sites.Authenticate(ctx, secret)
+	if errors.Is(err, storage.ErrTimeout) {
+		siteverifyInternalError(c, err)
+		return
+	} else if err != nil {
+		siteverifyFailure(c, siteverifyInvalidSecret)
+		return
+	}
+
+	claims, err := challenge.RedeemPassToken(ctx, response, site.Key)
+	switch {
+	case errors.Is(err, storage.ErrTimeout):
+		siteverifyInternalError(c, err)
+	case errors.Is(err, token.ErrExpired), errors.Is(err, challenge.ErrTokenRedeemed):
+		siteverifyFailure(c, siteverifyDuplicate)
+	case err != nil:
+		siteverifyFailure(c, siteverifyInvalidResponse)
+	default:
+		c.JSON(http.StatusOK, SiteverifyResponse{
+			Success:     true,
+			ChallengeTS: time.Unix(claims.IssuedAt, 0).UTC().Format(time.RFC3339),
+			Hostname:    claims.Hostname,
+			Action:      claims.Action,
+		})
+	}
+}
+
+// formValue returns a form field of the request body, or of the query
+// string, where some siteverify clients put it.
+func formValue(c *gin.Context, key string) string {
+	if value, ok := c.GetPostForm(key); ok {
+		return value
+	}
+	return c.Query(key)
+}
+
+// siteverifyFailure writes a siteverify response rejecting the token.
+func siteverifyFailure(c *gin.Context, codes ...string) {
+	c.JSON(http.StatusOK, SiteverifyResponse{Success: false, ErrorCodes: codes})
+}
+
+// siteverifyInternalError is internalError for siteverify responses.
+func siteverifyInternalError(c *gin.Context, err error) {
+	log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	status := http.StatusInternalServerError
+	if errors.Is(err, storage.ErrTimeout) {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, SiteverifyResponse{Success: false, ErrorCodes: []string{siteverifyInternal}})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ucaptcha/backend-go/challenge"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/token"
+)
+
+type TokenVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+type TokenVerifyResponse struct {
+	Success     bool   `json:"success"`
+	ChallengeID string `json:"challenge_id"`
+	Site        string `json:"site,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	Action      string `json:"action,omitempty"`
+	IssuedAt    string `json:"issued_at"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// verifyTokenHandler checks a pass token issued on a successful verification.
+func verifyTokenHandler(c *gin.Context) {
+	var req TokenVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		errorResponse(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request")
+		return
+	}
+
+	claims, err := challenge.VerifyPassToken(c.Request.Context(), req.Token)
+	switch {
+	case errors.Is(err, storage.ErrTimeout):
+		internalError(c, err)
+	case errors.Is(err, token.ErrExpired):
+		errorResponse(c, http.StatusUnauthorized, CodeTokenExpired, "Token expired")
+	case err != nil:
+		errorResponse(c, http.StatusUnauthorized, CodeInvalidToken, "Invalid token")
+	default:
+		c.JSON(http.StatusOK, TokenVerifyResponse{
+			Success:     true,
+			ChallengeID: claims.ChallengeID,
+			Site:        claims.Site,
+			Hostname:    claims.Hostname,
+			Action:      claims.Action,
+			IssuedAt:    time.Unix(claims.IssuedAt, 0).UTC().Format(time.RFC3339),
+			ExpiresAt:   time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// tokenKeysHandler publishes the pass token public keys as a JSON Web Key
+// Set, for services verifying pass tokens offline.
+func tokenKeysHandler(c *gin.Context) {
+	keys, err := challenge.PassTokenKeys(c.Request.Context())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
@@ -0,0 +1,141 @@
+package sites
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+// secretBytes is the number of random bytes of a secret.
+const secretBytes = 32
+
+// ErrInvalidSecret is returned, wrapped, when a secret belongs to no enabled site.
+var ErrInvalidSecret = errors.New("invalid site secret")
+
+// Authenticate returns the site a secret belongs to using the global manager.
+func Authenticate(ctx context.Context, secret string) (*storage.Site, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, err
+	}
+	return m.Authenticate(ctx, secret)
+}
+
+// RotateSecret replaces the secrets of a site using the global manager.
+func RotateSecret(ctx context.Context, key string) (string, error) {
+	m, err := manager()
+	if err != nil {
+		return "", err
+	}
+	return m.RotateSecret(ctx, key)
+}
+
+// HashSecret returns the hash a secret is stored under.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSecret generates and stores a new secret for the site with the
+// given key. A site may hold several secrets, so that a new one can be
+// deployed before the old one is deleted. Secrets are only stored as their
+// SHA-256 hash, so a secret is shown once, when it is created.
+func (m *Manager) CreateSecret(ctx context.Context, key string) (string, *storage.SiteSecret, error) {
+	if _, err := m.Site(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return m.newSecret(ctx, key)
+}
+
+// newSecret generates and stores a new secret for a site known to exist.
+func (m *Manager) newSecret(ctx context.Context, key string) (string, *storage.SiteSecret, error) {
+	raw := make([]byte, secretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	siteSecret := &storage.SiteSecret{
+		Hash:      HashSecret(secret),
+		Site:      key,
+		CreatedAt: time.Now(),
+	}
+	if err := m.secretStorage.SaveSecret(ctx, siteSecret); err != nil {
+		return "", nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+	return secret, siteSecret, nil
+}
+
+// RotateSecret creates a new secret for a site and deletes its previous
+// secrets, which stop working at once.
+func (m *Manager) RotateSecret(ctx context.Context, key string) (string, error) {
+	secret, created, err := m.CreateSecret(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	secrets, err := m.secretStorage.GetAllSecrets(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secrets: %w", err)
+	}
+	for _, old := range secrets {
+		if old.Site != key || old.Hash == created.Hash {
+			continue
+		}
+		if err := m.secretStorage.DeleteSecret(ctx, old.Hash); err != nil {
+			return "", fmt.Errorf("failed to delete previous secret: %w", err)
+		}
+	}
+	return secret, nil
+}
+
+// Authenticate returns the enabled site a secret belongs to. Storage
+// timeouts are returned as is, any other failure as ErrInvalidSecret.
+func (m *Manager) Authenticate(ctx context.Context, secret string) (*storage.Site, error) {
+	if secret == "" {
+		return nil, ErrInvalidSecret
+	}
+	siteSecret, err := m.secretStorage.GetSecret(ctx, HashSecret(secret))
+	if errors.Is(err, storage.ErrTimeout) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecret, err)
+	}
+	site, err := m.Lookup(ctx, siteSecret.Site)
+	if errors.Is(err, storage.ErrTimeout) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSecret, err)
+	}
+	return site, nil
+}
+
+// Secrets returns the stored secrets of all sites.
+func (m *Manager) Secrets(ctx context.Context) ([]*storage.SiteSecret, error) {
+	return m.secretStorage.GetAllSecrets(ctx)
+}
+
+// DeleteSecrets deletes every secret of the site with the given key and
+// returns how many were deleted.
+func (m *Manager) DeleteSecrets(ctx context.Context, key string) (int, error) {
+	secrets, err := m.secretStorage.GetAllSecrets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, secret := range secrets {
+		if secret.Site != key {
+			continue
+		}
+		if err := m.secretStorage.DeleteSecret(ctx, secret.Hash); err != nil {
+			return deleted, fmt.Errorf("failed to delete secret: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
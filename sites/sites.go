@@ -0,0 +1,293 @@
+// Package sites manages sites, the tenants of uCaptcha. Each site has a
+// public site key, sent along with challenge requests, and secrets it
+// authenticates with when it redeems pass tokens through siteverify.
+package sites
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ucaptcha/backend-go/lib"
+	"github.com/ucaptcha/backend-go/storage"
+)
+
+const (
+	siteKeyBytes  = 16              // Number of random bytes of a site key
+	siteCacheSize = 256             // Number of sites cached for challenge issuance and verification
+	siteCacheTTL  = 5 * time.Second // How long a cached site is served without re-reading it
+)
+
+// Errors returned, wrapped, by the site lookups.
+var (
+	ErrSiteNotFound = errors.New("site not found")
+	ErrSiteDisabled = errors.New("site is disabled")
+)
+
+var (
+	globalManager     *Manager
+	globalManagerOnce sync.Once
+)
+
+// SiteOptions configures a site created by CreateSite.
+type SiteOptions struct {
+	Name         string
+	Hostnames    []string      // Hostnames challenges may be issued for, any if empty
+	Difficulty   int64         // Default difficulty of VDF puzzles, 0 for the global default
+	ChallengeTTL time.Duration // Challenge lifetime, 0 for the global default
+}
+
+// Validate checks the options and normalizes the hostnames to lower case.
+func (o *SiteOptions) Validate() error {
+	for i, hostname := range o.Hostnames {
+		hostname = normalizeHostname(hostname)
+		if !validHostname(hostname) {
+			return fmt.Errorf("invalid hostname: %q", o.Hostnames[i])
+		}
+		o.Hostnames[i] = hostname
+	}
+	if o.Difficulty < 0 {
+		return fmt.Errorf("difficulty must not be negative")
+	}
+	if o.ChallengeTTL < 0 {
+		return fmt.Errorf("challenge TTL must not be negative")
+	}
+	return nil
+}
+
+// cachedSite is a site read from storage along with the time it was read.
+type cachedSite struct {
+	site     *storage.Site
+	loadedAt time.Time
+}
+
+// Manager handles the creation and lookup of sites and their secrets.
+//
+// Sites are looked up on every challenge request, so they are cached for
+// siteCacheTTL: a site disabled through another server takes up to that
+// long to be refused.
+type Manager struct {
+	siteStorage   storage.SiteStorage
+	secretStorage storage.SecretStorage
+	cache         *lib.LRU[string, cachedSite]
+}
+
+// NewManager creates a new Manager instance.
+func NewManager(siteStorage storage.SiteStorage, secretStorage storage.SecretStorage) *Manager {
+	return &Manager{
+		siteStorage:   siteStorage,
+		secretStorage: secretStorage,
+		cache:         lib.NewLRU[string, cachedSite](siteCacheSize),
+	}
+}
+
+// InitializeStorage sets up the global Manager instance.
+// Must be called before using the package-level functions.
+func InitializeStorage(siteStorage storage.SiteStorage, secretStorage storage.SecretStorage) {
+	globalManagerOnce.Do(func() {
+		globalManager = NewManager(siteStorage, secretStorage)
+	})
+}
+
+// manager returns the global manager, or an error if it is not initialized.
+func manager() (*Manager, error) {
+	if globalManager == nil {
+		return nil, fmt.Errorf("site storage not initialized")
+	}
+	return globalManager, nil
+}
+
+// CreateSite creates a site using the global manager.
+func CreateSite(ctx context.Context, opts SiteOptions) (*storage.Site, string, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, "", err
+	}
+	return m.CreateSite(ctx, opts)
+}
+
+// Site returns a site using the global manager.
+func Site(ctx context.Context, key string) (*storage.Site, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, err
+	}
+	return m.Site(ctx, key)
+}
+
+// Lookup returns an enabled site using the global manager.
+func Lookup(ctx context.Context, key string) (*storage.Site, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, err
+	}
+	return m.Lookup(ctx, key)
+}
+
+// Sites returns all sites using the global manager.
+func Sites(ctx context.Context) ([]*storage.Site, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, err
+	}
+	return m.Sites(ctx)
+}
+
+// SetDisabled disables or enables a site using the global manager.
+func SetDisabled(ctx context.Context, key string, disabled bool) (*storage.Site, error) {
+	m, err := manager()
+	if err != nil {
+		return nil, err
+	}
+	return m.SetDisabled(ctx, key, disabled)
+}
+
+// MaxChallengeTTL returns the longest site challenge TTL using the global manager.
+func MaxChallengeTTL(ctx context.Context) (time.Duration, error) {
+	m, err := manager()
+	if err != nil {
+		return 0, err
+	}
+	return m.MaxChallengeTTL(ctx)
+}
+
+// CreateSite creates a site with a new site key, along with its first
+// secret, which is returned in the clear.
+func (m *Manager) CreateSite(ctx context.Context, opts SiteOptions) (*storage.Site, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+	raw := make([]byte, siteKeyBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate site key: %v", err)
+	}
+	site := &storage.Site{
+		Key:          base64.RawURLEncoding.EncodeToString(raw),
+		Name:         opts.Name,
+		Hostnames:    opts.Hostnames,
+		Difficulty:   opts.Difficulty,
+		ChallengeTTL: opts.ChallengeTTL,
+		CreatedAt:    time.Now(),
+	}
+	if err := m.siteStorage.SaveSite(ctx, site); err != nil {
+		return nil, "", fmt.Errorf("failed to save site: %w", err)
+	}
+	secret, _, err := m.newSecret(ctx, site.Key)
+	if err != nil {
+		return nil, "", err
+	}
+	return site, secret, nil
+}
+
+// Site returns the site with the given key, enabled or not. Storage
+// timeouts are returned as is, any other failure as ErrSiteNotFound.
+func (m *Manager) Site(ctx context.Context, key string) (*storage.Site, error) {
+	if cached, ok := m.cache.Get(key); ok && time.Since(cached.loadedAt) < siteCacheTTL {
+		return cached.site, nil
+	}
+	site, err := m.siteStorage.GetSite(ctx, key)
+	if errors.Is(err, storage.ErrTimeout) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSiteNotFound, err)
+	}
+	m.cache.Add(key, cachedSite{site: site, loadedAt: time.Now()})
+	return site, nil
+}
+
+// Lookup returns the site with the given key, failing with ErrSiteDisabled
+// if it is disabled.
+func (m *Manager) Lookup(ctx context.Context, key string) (*storage.Site, error) {
+	site, err := m.Site(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if site.Disabled {
+		return nil, fmt.Errorf("%w: %s", ErrSiteDisabled, key)
+	}
+	return site, nil
+}
+
+// Sites returns all sites.
+func (m *Manager) Sites(ctx context.Context) ([]*storage.Site, error) {
+	return m.siteStorage.GetAllSites(ctx)
+}
+
+// SetDisabled disables or enables a site and returns it. Challenges of a
+// disabled site can be neither issued nor verified, and its pass tokens
+// cannot be redeemed.
+func (m *Manager) SetDisabled(ctx context.Context, key string, disabled bool) (*storage.Site, error) {
+	site, err := m.siteStorage.GetSite(ctx, key)
+	if errors.Is(err, storage.ErrTimeout) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSiteNotFound, err)
+	}
+	// Cached sites are shared, so the stored site is modified as a copy
+	updated := *site
+	updated.Disabled = disabled
+	if err := m.siteStorage.SaveSite(ctx, &updated); err != nil {
+		return nil, fmt.Errorf("failed to save site: %w", err)
+	}
+	m.cache.Remove(key)
+	return &updated, nil
+}
+
+// MaxChallengeTTL returns the longest challenge TTL set by a site, or 0 if
+// no site sets one.
+func (m *Manager) MaxChallengeTTL(ctx context.Context) (time.Duration, error) {
+	sites, err := m.siteStorage.GetAllSites(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	for _, site := range sites {
+		longest = max(longest, site.ChallengeTTL)
+	}
+	return longest, nil
+}
+
+// HostnameAllowed reports whether site may issue challenges for hostname:
+// any hostname if the site lists none, otherwise a listed hostname or one
+// of its subdomains.
+func HostnameAllowed(site *storage.Site, hostname string) bool {
+	if len(site.Hostnames) == 0 {
+		return true
+	}
+	hostname = normalizeHostname(hostname)
+	for _, allowed := range site.Hostnames {
+		if hostname == allowed || strings.HasSuffix(hostname, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHostname lower-cases a hostname and drops the trailing dot of
+// fully qualified names.
+func normalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+// validHostname reports whether a normalized hostname is made of valid DNS labels.
+func validHostname(hostname string) bool {
+	if hostname == "" || len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// Storage backends, as named in the configuration.
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+	BackendSQL    = "sql"
+	BackendBolt   = "bolt"
+)
+
+// Backends creates key, challenge, secret and site storages by backend name. The SQL and
+// bolt databases are opened on first use and shared by all storages using
+// them, as bolt allows a single handle per file.
+type Backends struct {
+	cfg    config.Config
+	sqlDB  *SQLDB
+	boltDB *BoltDB
+}
+
+// NewBackends creates a Backends instance for the given configuration.
+func NewBackends(cfg config.Config) *Backends {
+	return &Backends{cfg: cfg}
+}
+
+// KeyStorage creates a key storage on the named backend, memory if empty.
+func (b *Backends) KeyStorage(ctx context.Context, backend string) (KeyStorage, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemoryKeyStorage(), nil
+	case BackendRedis:
+		return NewRedisKeyStorage(ctx, b.cfg.Redis)
+	case BackendSQL:
+		db, err := b.sql(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLKeyStorage(db), nil
+	case BackendBolt:
+		db, err := b.bolt()
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltKeyStorage(db), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// ChallengeStorage creates a challenge storage on the named backend, memory if empty.
+func (b *Backends) ChallengeStorage(ctx context.Context, backend string) (ChallengeStorage, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemoryChallengeStorage(b.cfg.ChallengeMaxEntries), nil
+	case BackendRedis:
+		return NewRedisChallengeStorage(b.cfg.Redis)
+	case BackendSQL:
+		db, err := b.sql(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLChallengeStorage(db), nil
+	case BackendBolt:
+		db, err := b.bolt()
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltChallengeStorage(db), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// SecretStorage creates a site secret storage on the named backend, memory if empty.
+func (b *Backends) SecretStorage(ctx context.Context, backend string) (SecretStorage, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemorySecretStorage(), nil
+	case BackendRedis:
+		return NewRedisSecretStorage(b.cfg.Redis)
+	case BackendSQL:
+		db, err := b.sql(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLSecretStorage(db), nil
+	case BackendBolt:
+		db, err := b.bolt()
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltSecretStorage(db), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// SiteStorage creates a site storage on the named backend, memory if empty.
+func (b *Backends) SiteStorage(ctx context.Context, backend string) (SiteStorage, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemorySiteStorage(), nil
+	case BackendRedis:
+		return NewRedisSiteStorage(b.cfg.Redis)
+	case BackendSQL:
+		db, err := b.sql(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLSiteStorage(db), nil
+	case BackendBolt:
+		db, err := b.bolt()
+		if err != nil {
+			return nil, err
+		}
+		return NewBoltSiteStorage(db), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// sql returns the shared SQL database, opening it on first use.
+func (b *Backends) sql(ctx context.Context) (*SQLDB, error) {
+	if b.sqlDB == nil {
+		db, err := OpenSQL(ctx, b.cfg.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SQL database: %v", err)
+		}
+		b.sqlDB = db
+	}
+	return b.sqlDB, nil
+}
+
+// bolt returns the shared bolt database, opening it on first use.
+func (b *Backends) bolt() (*BoltDB, error) {
+	if b.boltDB == nil {
+		db, err := OpenBolt(b.cfg.Bolt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bolt database: %v", err)
+		}
+		b.boltDB = db
+	}
+	return b.boltDB, nil
+}
+
+// Close closes the databases opened for the storages. Storages must be
+// closed before.
+func (b *Backends) Close() error {
+	var errs []error
+	if b.sqlDB != nil {
+		errs = append(errs, b.sqlDB.Close())
+	}
+	if b.boltDB != nil {
+		errs = append(errs, b.boltDB.Close())
+	}
+	return errors.Join(errs...)
+}
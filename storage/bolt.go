@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBoltPath is the database file used when none is configured.
+const DefaultBoltPath = "ucaptcha.bolt"
+
+// Buckets of the bolt database, one per stored type.
+var (
+	boltKeysBucket        = []byte("keys")
+	boltChallengesBucket  = []byte("challenges")
+	boltRedemptionsBucket = []byte("redemptions")
+	boltSecretsBucket     = []byte("secrets")
+	boltSitesBucket       = []byte("sites")
+)
+
+// BoltDB is a bbolt database shared by the bolt key, challenge, secret and
+// site storages. bbolt locks its file, so a process opens it once for all of them.
+type BoltDB struct {
+	*bolt.DB
+}
+
+// OpenBolt opens or creates the configured bolt database file and its buckets.
+func OpenBolt(cfg config.BoltConfig) (*BoltDB, error) {
+	path := cfg.Path
+	if path == "" {
+		path = DefaultBoltPath
+	}
+	// Fail instead of blocking forever when another process holds the file
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltKeysBucket, boltChallengesBucket, boltRedemptionsBucket, boltSecretsBucket, boltSitesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %v", err)
+	}
+	return &BoltDB{DB: db}, nil
+}
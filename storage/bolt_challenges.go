@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ucaptcha/backend-go/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltChallengeStorage is a bbolt implementation of the ChallengeStorage interface.
+//
+// Each value is the time the challenge may be dropped, as 8-byte big-endian
+// Unix milliseconds, followed by the challenge as JSON. The prefix lets the
+// janitor find expired challenges without decoding them. Redemption records
+// hold that prefix alone, the time their token expires.
+type BoltChallengeStorage struct {
+	db        *BoltDB
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBoltChallengeStorage creates a new BoltChallengeStorage instance.
+func NewBoltChallengeStorage(db *BoltDB) ChallengeStorage {
+	s := &BoltChallengeStorage{
+		db:   db,
+		done: make(chan struct{}),
+	}
+	go s.janitor()
+	return s
+}
+
+// janitor periodically compacts the buckets by deleting challenges past
+// retention and redemption records whose token expired.
+func (s *BoltChallengeStorage) janitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			// Failures are retried on the next tick; stale entries are never returned
+			s.removeExpired(now)
+		}
+	}
+}
+
+// removeExpired deletes every challenge and redemption record that may be
+// dropped at now.
+func (s *BoltChallengeStorage) removeExpired(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltChallengesBucket, boltRedemptionsBucket} {
+			bucket := tx.Bucket(name)
+			// Collect first, deleting while iterating would make the cursor skip entries
+			var expired [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				if !retained(v, now) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// retained reports whether a stored challenge or redemption record may still
+// be returned at now.
+func retained(v []byte, now time.Time) bool {
+	return len(v) >= 8 && int64(binary.BigEndian.Uint64(v)) > now.UnixMilli()
+}
+
+// Save stores a challenge, replacing any challenge with the same ID.
+func (s *BoltChallengeStorage) Save(ctx context.Context, ch *types.Challenge) error {
+	jsonData, err := json.Marshal(ch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge: %v", err)
+	}
+	v := binary.BigEndian.AppendUint64(nil, uint64(RetainUntil(ch).UnixMilli()))
+	v = append(v, jsonData...)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChallengesBucket).Put([]byte(ch.ID), v)
+	})
+}
+
+// Get retrieves a challenge by its ID.
+func (s *BoltChallengeStorage) Get(ctx context.Context, id string) (*types.Challenge, error) {
+	var ch *types.Challenge
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ch, err = decodeBoltChallenge(id, tx.Bucket(boltChallengesBucket).Get([]byte(id)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Take retrieves and deletes a challenge in a single read-write transaction.
+func (s *BoltChallengeStorage) Take(ctx context.Context, id string) (*types.Challenge, error) {
+	var ch *types.Challenge
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltChallengesBucket)
+		var err error
+		if ch, err = decodeBoltChallenge(id, bucket.Get([]byte(id))); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// GetAll retrieves all challenges that are not past retention.
+func (s *BoltChallengeStorage) GetAll(ctx context.Context) ([]*types.Challenge, error) {
+	var challenges []*types.Challenge
+	err := s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		return tx.Bucket(boltChallengesBucket).ForEach(func(k, v []byte) error {
+			if !retained(v, now) {
+				return nil
+			}
+			ch, err := decodeBoltChallenge(string(k), v)
+			if err != nil {
+				return err
+			}
+			challenges = append(challenges, ch)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenges, nil
+}
+
+// Redeem records the redemption of a pass token in a single read-write
+// transaction.
+func (s *BoltChallengeStorage) Redeem(ctx context.Context, id string, until time.Time) (bool, error) {
+	now := time.Now()
+	if !until.After(now) {
+		return false, nil // The token already expired
+	}
+	redeemed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRedemptionsBucket)
+		if retained(bucket.Get([]byte(id)), now) {
+			return nil
+		}
+		redeemed = true
+		return bucket.Put([]byte(id), binary.BigEndian.AppendUint64(nil, uint64(until.UnixMilli())))
+	})
+	if err != nil {
+		return false, err
+	}
+	return redeemed, nil
+}
+
+// Delete removes a challenge by its ID. Deleting a missing challenge is not an error.
+func (s *BoltChallengeStorage) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChallengesBucket).Delete([]byte(id))
+	})
+}
+
+// Close stops the janitor. The database itself is closed by its owner.
+func (s *BoltChallengeStorage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// decodeBoltChallenge decodes a stored challenge, treating entries past
+// retention as missing. v is only valid inside its transaction.
+func decodeBoltChallenge(id string, v []byte) (*types.Challenge, error) {
+	if v == nil || !retained(v, time.Now()) {
+		return nil, fmt.Errorf("challenge not found: %s", id)
+	}
+	var ch types.Challenge
+	if err := json.Unmarshal(v[8:], &ch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal challenge %s: %v", id, err)
+	}
+	return &ch, nil
+}
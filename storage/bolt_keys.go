@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltKeyStorage is a bbolt implementation of the KeyStorage interface.
+// Key pairs are stored as JSON under their ID.
+type BoltKeyStorage struct {
+	db *BoltDB
+}
+
+// NewBoltKeyStorage creates a new BoltKeyStorage instance.
+func NewBoltKeyStorage(db *BoltDB) KeyStorage {
+	return &BoltKeyStorage{db: db}
+}
+
+func (s *BoltKeyStorage) GetKeyCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltKeysBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltKeyStorage) HasKey(ctx context.Context) (bool, error) {
+	var hasKey bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(boltKeysBucket).Cursor().First()
+		hasKey = k != nil
+		return nil
+	})
+	return hasKey, err
+}
+
+// SaveKey stores a key pair, replacing any key pair with the same ID.
+func (s *BoltKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
+	if key.ID == "" {
+		return fmt.Errorf("key must have an ID")
+	}
+	jsonData, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key pair: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).Put([]byte(key.ID), jsonData)
+	})
+}
+
+// GetRandomKey retrieves a random active key pair of the given type, picking
+// uniformly among eligible keys like MemoryKeyStorage.
+func (s *BoltKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) {
+	var picked *KeyPair
+	err := s.db.View(func(tx *bolt.Tx) error {
+		eligible := 0
+		return tx.Bucket(boltKeysBucket).ForEach(func(_, v []byte) error {
+			key, err := unmarshalKeyPair(string(v))
+			if err != nil {
+				return err
+			}
+			if key.GetType() != keyType || key.GetState() != KeyStateActive {
+				return nil
+			}
+			eligible++
+			if rand.IntN(eligible) == 0 {
+				picked = key
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return picked, nil
+}
+
+// GetKey retrieves a key pair by its ID.
+func (s *BoltKeyStorage) GetKey(ctx context.Context, id string) (*KeyPair, error) {
+	var key *KeyPair
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltKeysBucket).Get([]byte(id))
+		if v == nil {
+			return fmt.Errorf("key not found: %s", id)
+		}
+		var err error
+		key, err = unmarshalKeyPair(string(v))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeleteKey removes a key pair by its ID. Deleting a missing key is not an error.
+func (s *BoltKeyStorage) DeleteKey(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).Delete([]byte(id))
+	})
+}
+
+// GetAllKeys retrieves all stored key pairs.
+func (s *BoltKeyStorage) GetAllKeys(ctx context.Context) ([]*KeyPair, error) {
+	var keyList []*KeyPair
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).ForEach(func(_, v []byte) error {
+			key, err := unmarshalKeyPair(string(v))
+			if err != nil {
+				return err
+			}
+			keyList = append(keyList, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyList, nil
+}
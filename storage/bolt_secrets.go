@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltSecretStorage is a bbolt implementation of the SecretStorage interface.
+// Site secrets are stored as JSON under their hash.
+type BoltSecretStorage struct {
+	db *BoltDB
+}
+
+// NewBoltSecretStorage creates a new BoltSecretStorage instance.
+func NewBoltSecretStorage(db *BoltDB) SecretStorage {
+	return &BoltSecretStorage{db: db}
+}
+
+// SaveSecret stores a site secret, replacing any secret with the same hash.
+func (s *BoltSecretStorage) SaveSecret(ctx context.Context, secret *SiteSecret) error {
+	if secret.Hash == "" {
+		return fmt.Errorf("secret must have a hash")
+	}
+	jsonData, err := json.Marshal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSecretsBucket).Put([]byte(secret.Hash), jsonData)
+	})
+}
+
+// GetSecret retrieves a site secret by its hash.
+func (s *BoltSecretStorage) GetSecret(ctx context.Context, hash string) (*SiteSecret, error) {
+	var secret *SiteSecret
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltSecretsBucket).Get([]byte(hash))
+		if v == nil {
+			return fmt.Errorf("secret not found: %s", hash)
+		}
+		var err error
+		secret, err = unmarshalSecret(string(v))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// DeleteSecret removes a site secret by its hash. Deleting a missing secret is not an error.
+func (s *BoltSecretStorage) DeleteSecret(ctx context.Context, hash string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSecretsBucket).Delete([]byte(hash))
+	})
+}
+
+// GetAllSecrets retrieves all stored site secrets.
+func (s *BoltSecretStorage) GetAllSecrets(ctx context.Context) ([]*SiteSecret, error) {
+	var secrets []*SiteSecret
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSecretsBucket).ForEach(func(_, v []byte) error {
+			secret, err := unmarshalSecret(string(v))
+			if err != nil {
+				return err
+			}
+			secrets = append(secrets, secret)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltSiteStorage is a bbolt implementation of the SiteStorage interface.
+// Sites are stored as JSON under their key.
+type BoltSiteStorage struct {
+	db *BoltDB
+}
+
+// NewBoltSiteStorage creates a new BoltSiteStorage instance.
+func NewBoltSiteStorage(db *BoltDB) SiteStorage {
+	return &BoltSiteStorage{db: db}
+}
+
+// SaveSite stores a site, replacing any site with the same key.
+func (s *BoltSiteStorage) SaveSite(ctx context.Context, site *Site) error {
+	if site.Key == "" {
+		return fmt.Errorf("site must have a key")
+	}
+	jsonData, err := json.Marshal(site)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSitesBucket).Put([]byte(site.Key), jsonData)
+	})
+}
+
+// GetSite retrieves a site by its key.
+func (s *BoltSiteStorage) GetSite(ctx context.Context, key string) (*Site, error) {
+	var site *Site
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltSitesBucket).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("site not found: %s", key)
+		}
+		var err error
+		site, err = unmarshalSite(string(v))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return site, nil
+}
+
+// GetAllSites retrieves all stored sites.
+func (s *BoltSiteStorage) GetAllSites(ctx context.Context) ([]*Site, error) {
+	var sites []*Site
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSitesBucket).ForEach(func(_, v []byte) error {
+			site, err := unmarshalSite(string(v))
+			if err != nil {
+				return err
+			}
+			sites = append(sites, site)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
@@ -0,0 +1,177 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/storage/storagetest"
+	"github.com/ucaptcha/backend-go/types"
+	"go.etcd.io/bbolt"
+)
+
+// openBolt opens a bolt database in a temporary directory.
+func openBolt(t *testing.T) *storage.BoltDB {
+	db, err := storage.OpenBolt(config.BoltConfig{Path: filepath.Join(t.TempDir(), "ucaptcha.bolt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltKeyStorage(t *testing.T) {
+	storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+		return storage.NewBoltKeyStorage(openBolt(t))
+	})
+}
+
+func TestBoltChallengeStorage(t *testing.T) {
+	storagetest.TestChallengeStorage(t, func(t *testing.T) storage.ChallengeStorage {
+		cs := storage.NewBoltChallengeStorage(openBolt(t))
+		t.Cleanup(func() { cs.Close() })
+		return cs
+	})
+}
+
+func TestBoltSecretStorage(t *testing.T) {
+	storagetest.TestSecretStorage(t, func(t *testing.T) storage.SecretStorage {
+		return storage.NewBoltSecretStorage(openBolt(t))
+	})
+}
+
+func TestBoltSiteStorage(t *testing.T) {
+	storagetest.TestSiteStorage(t, func(t *testing.T) storage.SiteStorage {
+		return storage.NewBoltSiteStorage(openBolt(t))
+	})
+}
+
+// Missing IDs and repeated saves must behave exactly like in memory.
+func TestBoltMatchesMemory(t *testing.T) {
+	ctx := t.Context()
+	db := openBolt(t)
+
+	t.Run("Keys", func(t *testing.T) {
+		bolt := storage.NewBoltKeyStorage(db)
+		memory := storage.NewMemoryKeyStorage()
+		for _, ks := range []storage.KeyStorage{bolt, memory} {
+			for _, state := range []storage.KeyState{storage.KeyStateActive, storage.KeyStateVerifyOnly} {
+				if err := ks.SaveKey(ctx, &storage.KeyPair{ID: "k1", State: state}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		boltKey, boltErr := bolt.GetKey(ctx, "missing")
+		memoryKey, memoryErr := memory.GetKey(ctx, "missing")
+		if boltKey != nil || memoryKey != nil || boltErr == nil || memoryErr == nil || boltErr.Error() != memoryErr.Error() {
+			t.Errorf("GetKey of a missing ID: bolt returned %v, %v; memory returned %v, %v", boltKey, boltErr, memoryKey, memoryErr)
+		}
+
+		boltKey, boltErr = bolt.GetKey(ctx, "k1")
+		memoryKey, memoryErr = memory.GetKey(ctx, "k1")
+		if boltErr != nil || memoryErr != nil {
+			t.Fatalf("GetKey after two saves: bolt returned %v, memory returned %v", boltErr, memoryErr)
+		}
+		if boltKey.State != memoryKey.State {
+			t.Errorf("after two saves, bolt holds a key in state %q and memory one in state %q", boltKey.State, memoryKey.State)
+		}
+		boltCount, _ := bolt.GetKeyCount(ctx)
+		memoryCount, _ := memory.GetKeyCount(ctx)
+		if boltCount != memoryCount {
+			t.Errorf("after two saves, bolt counts %d keys and memory counts %d", boltCount, memoryCount)
+		}
+	})
+
+	t.Run("Challenges", func(t *testing.T) {
+		bolt := storage.NewBoltChallengeStorage(db)
+		t.Cleanup(func() { bolt.Close() })
+		memory := storage.NewMemoryChallengeStorage(0)
+		t.Cleanup(func() { memory.Close() })
+		expiresAt := time.Now().Add(time.Minute)
+		for _, cs := range []storage.ChallengeStorage{bolt, memory} {
+			for _, action := range []string{"first", "second"} {
+				if err := cs.Save(ctx, &types.Challenge{ID: "c1", Action: action, ExpiresAt: expiresAt}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		boltChallenge, boltErr := bolt.Get(ctx, "missing")
+		memoryChallenge, memoryErr := memory.Get(ctx, "missing")
+		if boltChallenge != nil || memoryChallenge != nil || boltErr == nil || memoryErr == nil || boltErr.Error() != memoryErr.Error() {
+			t.Errorf("Get of a missing ID: bolt returned %v, %v; memory returned %v, %v", boltChallenge, boltErr, memoryChallenge, memoryErr)
+		}
+
+		boltChallenge, boltErr = bolt.Get(ctx, "c1")
+		memoryChallenge, memoryErr = memory.Get(ctx, "c1")
+		if boltErr != nil || memoryErr != nil {
+			t.Fatalf("Get after two saves: bolt returned %v, memory returned %v", boltErr, memoryErr)
+		}
+		if boltChallenge.Action != memoryChallenge.Action {
+			t.Errorf("after two saves, bolt holds %q and memory holds %q", boltChallenge.Action, memoryChallenge.Action)
+		}
+		boltAll, _ := bolt.GetAll(ctx)
+		memoryAll, _ := memory.GetAll(ctx)
+		if len(boltAll) != len(memoryAll) {
+			t.Errorf("after two saves, bolt holds %d challenges and memory holds %d", len(boltAll), len(memoryAll))
+		}
+	})
+}
+
+// The janitor deletes challenges past retention and redemption records of
+// expired tokens from the file, not just hides them.
+func TestBoltCompaction(t *testing.T) {
+	ctx := t.Context()
+	db := openBolt(t)
+	cs := storage.NewBoltChallengeStorage(db).(*storage.BoltChallengeStorage)
+	t.Cleanup(func() { cs.Close() })
+
+	now := time.Now()
+	challenges := []*types.Challenge{
+		{ID: "live", ExpiresAt: now.Add(time.Minute)},
+		{ID: "dropped", ExpiresAt: now.Add(-2 * storage.ExpiredRetention)},
+	}
+	for _, ch := range challenges {
+		if err := cs.Save(ctx, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cs.Redeem(ctx, "live", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Redeem(ctx, "redeemed", now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.RemoveExpired(now.Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := boltKeys(t, db, "challenges"); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("challenges left after compaction = %v, want [live]", got)
+	}
+	if got := boltKeys(t, db, "redemptions"); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("redemption records left after compaction = %v, want [live]", got)
+	}
+	if _, err := cs.Get(ctx, "live"); err != nil {
+		t.Errorf("live challenge is gone after compaction: %v", err)
+	}
+}
+
+// boltKeys lists the keys stored in a bucket, in order.
+func boltKeys(t *testing.T, db *storage.BoltDB, bucket string) []string {
+	var keys []string
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
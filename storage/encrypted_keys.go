@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MasterKey is an AES-GCM key used to seal key material at rest.
+type MasterKey struct {
+	ID   string // Derived from the key itself, stored alongside sealed data
+	aead cipher.AEAD
+}
+
+// NewMasterKey creates a master key from 16, 24 or 32 bytes of secret material.
+func NewMasterKey(secret []byte) (*MasterKey, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(secret)
+	return &MasterKey{
+		ID:   hex.EncodeToString(digest[:8]),
+		aead: aead,
+	}, nil
+}
+
+// ParseMasterKey decodes a base64-encoded master key.
+func ParseMasterKey(encoded string) (*MasterKey, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %v", err)
+	}
+	return NewMasterKey(secret)
+}
+
+// LoadMasterKeyFile reads a base64-encoded master key from a file.
+func LoadMasterKeyFile(path string) (*MasterKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %v", err)
+	}
+	return ParseMasterKey(string(data))
+}
+
+// LoadMasterKeyEnv reads a base64-encoded master key from an environment variable.
+func LoadMasterKeyEnv(name string) (*MasterKey, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return ParseMasterKey(encoded)
+}
+
+// sealedSecrets is the plaintext sealed into KeyPair.Sealed.
+type sealedSecrets struct {
+	P       json.RawMessage `json:"p"`
+	Q       json.RawMessage `json:"q"`
+	Ed25519 []byte          `json:"ed25519,omitempty"` // Ed25519 private key seed
+}
+
+// EncryptedKeyStorage wraps a KeyStorage and seals the RSA factors and
+// Ed25519 private keys of every key with AES-GCM before they reach the
+// underlying storage, so reading the storage alone does not reveal the
+// factorizations. Keys are sealed with the current master key; previous
+// master keys are only used for opening, which allows rotating the master
+// key with ReEncrypt.
+type EncryptedKeyStorage struct {
+	inner      KeyStorage
+	current    *MasterKey
+	masterKeys map[string]*MasterKey
+}
+
+// NewEncryptedKeyStorage wraps inner, sealing keys with current and opening
+// keys sealed with current or any of the previous master keys.
+func NewEncryptedKeyStorage(inner KeyStorage, current *MasterKey, previous ...*MasterKey) *EncryptedKeyStorage {
+	masterKeys := map[string]*MasterKey{current.ID: current}
+	for _, mk := range previous {
+		masterKeys[mk.ID] = mk
+	}
+	return &EncryptedKeyStorage{
+		inner:      inner,
+		current:    current,
+		masterKeys: masterKeys,
+	}
+}
+
+// seal returns a copy of key with its secrets replaced by a ciphertext.
+// Keys without secrets are returned unchanged.
+func (s *EncryptedKeyStorage) seal(key *KeyPair) (*KeyPair, error) {
+	if !hasPlainSecrets(key) {
+		return key, nil
+	}
+	p, err := json.Marshal(key.Components.P)
+	if err != nil {
+		return nil, err
+	}
+	q, err := json.Marshal(key.Components.Q)
+	if err != nil {
+		return nil, err
+	}
+	secrets := sealedSecrets{P: p, Q: q}
+	if key.Ed25519 != nil {
+		secrets.Ed25519 = key.Ed25519.Private
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := *key
+	sealed.Components = RSAComponents{N: key.Components.N}
+	if key.Ed25519 != nil {
+		sealed.Ed25519 = &Ed25519Components{Public: key.Ed25519.Public}
+	}
+	// The key ID is authenticated so sealed factors cannot be moved between keys
+	sealed.Sealed = s.current.aead.Seal(nonce, nonce, plaintext, []byte(key.ID))
+	sealed.MasterKeyID = s.current.ID
+	return &sealed, nil
+}
+
+// open returns a copy of key with its RSA factors decrypted.
+// Keys that were stored unsealed are returned unchanged.
+func (s *EncryptedKeyStorage) open(key *KeyPair) (*KeyPair, error) {
+	if key == nil || key.Sealed == nil {
+		return key, nil
+	}
+	mk, ok := s.masterKeys[key.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s for key %s is not available", key.MasterKeyID, key.ID)
+	}
+	nonceSize := mk.aead.NonceSize()
+	if len(key.Sealed) < nonceSize {
+		return nil, fmt.Errorf("sealed data of key %s is truncated", key.ID)
+	}
+	plaintext, err := mk.aead.Open(nil, key.Sealed[:nonceSize], key.Sealed[nonceSize:], []byte(key.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %v", key.ID, err)
+	}
+
+	var secrets sealedSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secrets of key %s: %v", key.ID, err)
+	}
+	opened := *key
+	opened.Sealed = nil
+	opened.MasterKeyID = ""
+	opened.Components = RSAComponents{N: key.Components.N}
+	if err := json.Unmarshal(secrets.P, &opened.Components.P); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(secrets.Q, &opened.Components.Q); err != nil {
+		return nil, err
+	}
+	if key.Ed25519 != nil {
+		opened.Ed25519 = &Ed25519Components{Public: key.Ed25519.Public, Private: secrets.Ed25519}
+	}
+	return &opened, nil
+}
+
+// hasPlainSecrets reports whether key holds unsealed secret material.
+func hasPlainSecrets(key *KeyPair) bool {
+	return key.Components.P != nil || key.Components.Q != nil ||
+		(key.Ed25519 != nil && key.Ed25519.Private != nil)
+}
+
+// SaveKey seals the key and stores it in the underlying storage.
+func (s *EncryptedKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
+	sealed, err := s.seal(key)
+	if err != nil {
+		return fmt.Errorf("failed to seal key %s: %v", key.ID, err)
+	}
+	return s.inner.SaveKey(ctx, sealed)
+}
+
+// GetKey retrieves and opens a key.
+func (s *EncryptedKeyStorage) GetKey(ctx context.Context, id string) (*KeyPair, error) {
+	key, err := s.inner.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(key)
+}
+
+// DeleteKey removes a key from the underlying storage.
+func (s *EncryptedKeyStorage) DeleteKey(ctx context.Context, id string) error {
+	return s.inner.DeleteKey(ctx, id)
+}
+
+// GetAllKeys retrieves and opens all keys.
+func (s *EncryptedKeyStorage) GetAllKeys(ctx context.Context) ([]*KeyPair, error) {
+	keys, err := s.inner.GetAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opened := make([]*KeyPair, 0, len(keys))
+	for _, key := range keys {
+		o, err := s.open(key)
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, o)
+	}
+	return opened, nil
+}
+
+// GetKeyCount returns the number of keys in the underlying storage.
+func (s *EncryptedKeyStorage) GetKeyCount(ctx context.Context) (int, error) {
+	return s.inner.GetKeyCount(ctx)
+}
+
+// GetRandomKey retrieves and opens a random active key of the given type.
+func (s *EncryptedKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) {
+	key, err := s.inner.GetRandomKey(ctx, keyType)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(key)
+}
+
+// HasKey reports whether the underlying storage holds any key.
+func (s *EncryptedKeyStorage) HasKey(ctx context.Context) (bool, error) {
+	return s.inner.HasKey(ctx)
+}
+
+// ReEncrypt seals every key that is stored in plaintext or under a previous
+// master key with the current master key, and returns the number of keys
+// rewritten. Run it after rotating the master key, before dropping the
+// previous one.
+func (s *EncryptedKeyStorage) ReEncrypt(ctx context.Context) (int, error) {
+	keys, err := s.inner.GetAllKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		hasSecrets := key.Sealed != nil || hasPlainSecrets(key)
+		if !hasSecrets || key.MasterKeyID == s.current.ID {
+			continue
+		}
+		opened, err := s.open(key)
+		if err != nil {
+			return count, err
+		}
+		if err := s.SaveKey(ctx, opened); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,163 @@
+package storage_test
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/storage/storagetest"
+)
+
+func newMasterKey(t *testing.T, fill byte) *storage.MasterKey {
+	mk, err := storage.NewMasterKey(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mk
+}
+
+// secretKeys returns an RSA key and an Ed25519 key holding secrets.
+func secretKeys() []*storage.KeyPair {
+	return []*storage.KeyPair{
+		{
+			ID:         "rsa",
+			Type:       storage.KeyTypeRSA,
+			Components: storage.RSAComponents{P: big.NewInt(61), Q: big.NewInt(53), N: big.NewInt(3233)},
+		},
+		{
+			ID:      "ed25519",
+			Type:    storage.KeyTypeEd25519,
+			Ed25519: &storage.Ed25519Components{Public: bytes.Repeat([]byte{1}, 32), Private: bytes.Repeat([]byte{2}, 32)},
+		},
+	}
+}
+
+func TestEncryptedKeyStorage(t *testing.T) {
+	masterKey := newMasterKey(t, 0)
+	storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+		return storage.NewEncryptedKeyStorage(storage.NewMemoryKeyStorage(), masterKey)
+	})
+}
+
+// Secrets reach the underlying storage sealed only, and come back intact.
+func TestEncryptedKeyStorageSeals(t *testing.T) {
+	ctx := t.Context()
+	masterKey := newMasterKey(t, 0)
+	inner := storage.NewMemoryKeyStorage()
+	ks := storage.NewEncryptedKeyStorage(inner, masterKey)
+
+	for _, key := range secretKeys() {
+		if err := ks.SaveKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := inner.GetKey(ctx, key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Sealed == nil || stored.MasterKeyID != masterKey.ID {
+			t.Errorf("key %s is stored unsealed", key.ID)
+		}
+		if stored.Components.P != nil || stored.Components.Q != nil {
+			t.Errorf("key %s is stored with its factors", key.ID)
+		}
+		if stored.Ed25519 != nil && stored.Ed25519.Private != nil {
+			t.Errorf("key %s is stored with its private seed", key.ID)
+		}
+		if key.Components.N != nil && stored.Components.N.Cmp(key.Components.N) != 0 {
+			t.Errorf("key %s is stored without its public modulus", key.ID)
+		}
+
+		opened, err := ks.GetKey(ctx, key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSecrets(t, opened, key)
+	}
+}
+
+// ReEncrypt moves keys stored in plaintext or under a previous master key
+// to the current one, without losing their secrets.
+func TestEncryptedKeyStorageReEncrypt(t *testing.T) {
+	ctx := t.Context()
+	previous, current := newMasterKey(t, 0), newMasterKey(t, 1)
+	inner := storage.NewMemoryKeyStorage()
+	keys := secretKeys()
+
+	// The RSA key is stored in plaintext, the Ed25519 key under the previous master key
+	if err := inner.SaveKey(ctx, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewEncryptedKeyStorage(inner, previous).SaveKey(ctx, keys[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	ks := storage.NewEncryptedKeyStorage(inner, current, previous)
+	count, err := ks.ReEncrypt(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("ReEncrypt rewrote %d keys, want 2", count)
+	}
+	for _, key := range keys {
+		stored, err := inner.GetKey(ctx, key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Sealed == nil || stored.MasterKeyID != current.ID {
+			t.Errorf("key %s is sealed with master key %q, want %q", key.ID, stored.MasterKeyID, current.ID)
+		}
+		// Only the current master key is needed from now on
+		opened, err := storage.NewEncryptedKeyStorage(inner, current).GetKey(ctx, key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSecrets(t, opened, key)
+	}
+
+	if count, err := ks.ReEncrypt(ctx); err != nil || count != 0 {
+		t.Errorf("second ReEncrypt rewrote %d keys (err %v), want 0", count, err)
+	}
+}
+
+// Keys sealed with a master key that is not configured cannot be opened.
+func TestEncryptedKeyStorageUnknownMasterKey(t *testing.T) {
+	ctx := t.Context()
+	inner := storage.NewMemoryKeyStorage()
+	if err := storage.NewEncryptedKeyStorage(inner, newMasterKey(t, 0)).SaveKey(ctx, secretKeys()[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	ks := storage.NewEncryptedKeyStorage(inner, newMasterKey(t, 1))
+	if key, err := ks.GetKey(ctx, "rsa"); err == nil {
+		t.Errorf("GetKey opened a key sealed with an unknown master key: %v", key)
+	}
+	if _, err := ks.GetAllKeys(ctx); err == nil {
+		t.Error("GetAllKeys opened a key sealed with an unknown master key")
+	}
+}
+
+// assertSecrets checks that got holds the secrets of want.
+func assertSecrets(t *testing.T, got, want *storage.KeyPair) {
+	t.Helper()
+	if got.Sealed != nil || got.MasterKeyID != "" {
+		t.Errorf("key %s is returned sealed", want.ID)
+	}
+	if !equalInt(got.Components.P, want.Components.P) || !equalInt(got.Components.Q, want.Components.Q) {
+		t.Errorf("key %s has factors %v, %v, want %v, %v", want.ID, got.Components.P, got.Components.Q, want.Components.P, want.Components.Q)
+	}
+	if want.Ed25519 != nil {
+		if got.Ed25519 == nil || !bytes.Equal(got.Ed25519.Private, want.Ed25519.Private) || !bytes.Equal(got.Ed25519.Public, want.Ed25519.Public) {
+			t.Errorf("key %s has Ed25519 key %v, want %v", want.ID, got.Ed25519, want.Ed25519)
+		}
+	}
+}
+
+func equalInt(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
@@ -0,0 +1,14 @@
+package storage
+
+import "time"
+
+// RemoveExpired runs one pass of the janitor at now.
+func (s *BoltChallengeStorage) RemoveExpired(now time.Time) error {
+	return s.removeExpired(now)
+}
+
+// SetBeforeRepair sets a function RebuildIndex calls for each key while it
+// is watched, between reading and indexing it.
+func (s *RedisKeyStorage) SetBeforeRepair(hook func(id string)) {
+	s.beforeRepair = hook
+}
@@ -1,48 +1,183 @@
 package storage
 
 import (
+	"container/list"
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ucaptcha/backend-go/types"
 )
 
+// DefaultMaxChallenges is the capacity of MemoryStorage when none is configured.
+const DefaultMaxChallenges = 100000
+
+// janitorInterval is how often MemoryStorage drops challenges past retention.
+const janitorInterval = 30 * time.Second
+
 // MemoryStorage is an in-memory implementation of the ChallengeStorage interface.
+// It holds at most maxEntries challenges, evicting the oldest when full, and
+// a background janitor drops challenges once they are past retention, and
+// redemption records once their token expired.
 type MemoryStorage struct {
-	challenges map[string]*types.Challenge
+	challenges map[string]*list.Element
+	order      *list.List // Challenges in insertion order, oldest first
+	maxEntries int
+	redeemed   map[string]time.Time // Redeemed challenge IDs, with the time their record may be dropped
 	mu         sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewMemoryChallengeStorage creates a new MemoryStorage instance holding at
+// most maxEntries challenges, or DefaultMaxChallenges if maxEntries is not positive.
+func NewMemoryChallengeStorage(maxEntries int) ChallengeStorage {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxChallenges
+	}
+	s := &MemoryStorage{
+		challenges: make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		redeemed:   make(map[string]time.Time),
+		done:       make(chan struct{}),
+	}
+	go s.janitor()
+	return s
+}
+
+// janitor periodically removes challenges that are past retention.
+func (s *MemoryStorage) janitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.removeExpired(now)
+		}
+	}
+}
+
+// removeExpired removes every challenge and redemption record that may be
+// dropped at now.
+func (s *MemoryStorage) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for e := s.order.Front(); e != nil; {
+		next := e.Next()
+		if ch := e.Value.(*types.Challenge); now.After(RetainUntil(ch)) {
+			s.remove(e)
+		}
+		e = next
+	}
+	for id, until := range s.redeemed {
+		if now.After(until) {
+			delete(s.redeemed, id)
+		}
+	}
+}
+
+// remove unlinks a challenge. The caller must hold s.mu.
+func (s *MemoryStorage) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.challenges, e.Value.(*types.Challenge).ID)
 }
 
-// NewMemoryChallengeStorage creates a new MemoryStorage instance.
-func NewMemoryChallengeStorage() ChallengeStorage {
-	return &MemoryStorage{
-		challenges: make(map[string]*types.Challenge),
+// lookup finds a challenge, removing it instead if it is past retention but
+// the janitor has not run yet. The caller must hold s.mu.
+func (s *MemoryStorage) lookup(id string) (*list.Element, bool) {
+	e, ok := s.challenges[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(RetainUntil(e.Value.(*types.Challenge))) {
+		s.remove(e)
+		return nil, false
 	}
+	return e, true
 }
 
-// Save stores a challenge in memory.
-func (s *MemoryStorage) Save(ch *types.Challenge) error {
+// Save stores a challenge in memory, evicting the oldest challenge when full.
+func (s *MemoryStorage) Save(ctx context.Context, ch *types.Challenge) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.challenges[ch.ID] = ch
+	if e, ok := s.challenges[ch.ID]; ok {
+		s.remove(e)
+	}
+	for s.order.Len() >= s.maxEntries {
+		s.remove(s.order.Front())
+	}
+	s.challenges[ch.ID] = s.order.PushBack(ch)
 	return nil
 }
 
 // Get retrieves a challenge from memory by its ID.
-func (s *MemoryStorage) Get(id string) (*types.Challenge, error) {
+func (s *MemoryStorage) Get(ctx context.Context, id string) (*types.Challenge, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	ch, ok := s.challenges[id]
+	e, ok := s.lookup(id)
 	if !ok {
 		return nil, fmt.Errorf("challenge not found: %s", id)
 	}
-	return ch, nil
+	return e.Value.(*types.Challenge), nil
+}
+
+// Take retrieves and removes a challenge from memory under a single lock.
+func (s *MemoryStorage) Take(ctx context.Context, id string) (*types.Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("challenge not found: %s", id)
+	}
+	s.remove(e)
+	return e.Value.(*types.Challenge), nil
+}
+
+// GetAll retrieves all challenges held in memory, oldest first.
+func (s *MemoryStorage) GetAll(ctx context.Context) ([]*types.Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	challenges := make([]*types.Challenge, 0, s.order.Len())
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		if ch := e.Value.(*types.Challenge); !now.After(RetainUntil(ch)) {
+			challenges = append(challenges, ch)
+		}
+	}
+	return challenges, nil
+}
+
+// Redeem records the redemption of a pass token under the lock.
+func (s *MemoryStorage) Redeem(ctx context.Context, id string, until time.Time) (bool, error) {
+	now := time.Now()
+	if !until.After(now) {
+		return false, nil // The token already expired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.redeemed[id]; ok && !now.After(prev) {
+		return false, nil
+	}
+	s.redeemed[id] = until
+	return true, nil
 }
 
 // Delete removes a challenge from memory by its ID.
-func (s *MemoryStorage) Delete(id string) error {
+func (s *MemoryStorage) Delete(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.challenges, id)
+	if e, ok := s.challenges[id]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+// Close stops the janitor.
+func (s *MemoryStorage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
 	return nil
 }
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"math/rand/v2"
 	"sync"
 )
 
@@ -18,14 +20,14 @@ func NewMemoryKeyStorage() KeyStorage {
 	}
 }
 
-func (s *MemoryKeyStorage) HasKey() (bool, error) {
+func (s *MemoryKeyStorage) HasKey(ctx context.Context) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return len(s.keys) > 0, nil
 }
 
 // SaveKey stores a key pair in memory.
-func (s *MemoryKeyStorage) SaveKey(key *KeyPair) error {
+func (s *MemoryKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if key.ID == "" {
@@ -35,25 +37,29 @@ func (s *MemoryKeyStorage) SaveKey(key *KeyPair) error {
 	return nil
 }
 
-// GetRandomKey retrieves a random key pair from memory.
-func (s *MemoryKeyStorage) GetRandomKey() (*KeyPair, error) {
+// GetRandomKey retrieves a random active key pair of the given type from memory.
+func (s *MemoryKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.keys) == 0 {
-		return nil, nil
-	}
-
-	// Get a random key from the map
+	// Reservoir sampling: the i-th eligible key replaces the pick with
+	// probability 1/i, so every eligible key is picked with equal probability.
+	var picked *KeyPair
+	eligible := 0
 	for _, key := range s.keys {
-		return key, nil
+		if key.GetType() != keyType || key.GetState() != KeyStateActive {
+			continue
+		}
+		eligible++
+		if rand.IntN(eligible) == 0 {
+			picked = key
+		}
 	}
-
-	return nil, nil
+	return picked, nil
 }
 
 // GetKey retrieves a key pair from memory by its ID.
-func (s *MemoryKeyStorage) GetKey(id string) (*KeyPair, error) {
+func (s *MemoryKeyStorage) GetKey(ctx context.Context, id string) (*KeyPair, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	key, ok := s.keys[id]
@@ -64,7 +70,7 @@ func (s *MemoryKeyStorage) GetKey(id string) (*KeyPair, error) {
 }
 
 // DeleteKey removes a key pair from memory by its ID.
-func (s *MemoryKeyStorage) DeleteKey(id string) error {
+func (s *MemoryKeyStorage) DeleteKey(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.keys, id)
@@ -72,7 +78,7 @@ func (s *MemoryKeyStorage) DeleteKey(id string) error {
 }
 
 // GetAllKeys retrieves all key pairs currently stored in memory.
-func (s *MemoryKeyStorage) GetAllKeys() ([]*KeyPair, error) {
+func (s *MemoryKeyStorage) GetAllKeys(ctx context.Context) ([]*KeyPair, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	keyList := make([]*KeyPair, 0, len(s.keys))
@@ -82,7 +88,7 @@ func (s *MemoryKeyStorage) GetAllKeys() ([]*KeyPair, error) {
 	return keyList, nil
 }
 
-func (s *MemoryKeyStorage) GetKeyCount() (int, error) {
+func (s *MemoryKeyStorage) GetKeyCount(ctx context.Context) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return len(s.keys), nil
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemorySecretStorage is an in-memory implementation of the SecretStorage interface.
+type MemorySecretStorage struct {
+	secrets map[string]*SiteSecret
+	mu      sync.RWMutex
+}
+
+// NewMemorySecretStorage creates a new MemorySecretStorage instance.
+func NewMemorySecretStorage() SecretStorage {
+	return &MemorySecretStorage{
+		secrets: make(map[string]*SiteSecret),
+	}
+}
+
+// SaveSecret stores a site secret in memory.
+func (s *MemorySecretStorage) SaveSecret(ctx context.Context, secret *SiteSecret) error {
+	if secret.Hash == "" {
+		return fmt.Errorf("secret must have a hash")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[secret.Hash] = secret
+	return nil
+}
+
+// GetSecret retrieves a site secret from memory by its hash.
+func (s *MemorySecretStorage) GetSecret(ctx context.Context, hash string) (*SiteSecret, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	secret, ok := s.secrets[hash]
+	if !ok {
+		return nil, fmt.Errorf("secret not found: %s", hash)
+	}
+	return secret, nil
+}
+
+// DeleteSecret removes a site secret from memory by its hash.
+func (s *MemorySecretStorage) DeleteSecret(ctx context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, hash)
+	return nil
+}
+
+// GetAllSecrets retrieves all site secrets currently stored in memory.
+func (s *MemorySecretStorage) GetAllSecrets(ctx context.Context) ([]*SiteSecret, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	secrets := make([]*SiteSecret, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemorySiteStorage is an in-memory implementation of the SiteStorage interface.
+type MemorySiteStorage struct {
+	sites map[string]*Site
+	mu    sync.RWMutex
+}
+
+// NewMemorySiteStorage creates a new MemorySiteStorage instance.
+func NewMemorySiteStorage() SiteStorage {
+	return &MemorySiteStorage{
+		sites: make(map[string]*Site),
+	}
+}
+
+// SaveSite stores a site in memory.
+func (s *MemorySiteStorage) SaveSite(ctx context.Context, site *Site) error {
+	if site.Key == "" {
+		return fmt.Errorf("site must have a key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sites[site.Key] = site
+	return nil
+}
+
+// GetSite retrieves a site from memory by its key.
+func (s *MemorySiteStorage) GetSite(ctx context.Context, key string) (*Site, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	site, ok := s.sites[key]
+	if !ok {
+		return nil, fmt.Errorf("site not found: %s", key)
+	}
+	return site, nil
+}
+
+// GetAllSites retrieves all sites currently stored in memory.
+func (s *MemorySiteStorage) GetAllSites(ctx context.Context) ([]*Site, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sites := make([]*Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
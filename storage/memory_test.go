@@ -0,0 +1,61 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/storage/storagetest"
+)
+
+func TestMemoryKeyStorage(t *testing.T) {
+	storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+		return storage.NewMemoryKeyStorage()
+	})
+}
+
+func TestMemoryChallengeStorage(t *testing.T) {
+	storagetest.TestChallengeStorage(t, func(t *testing.T) storage.ChallengeStorage {
+		cs := storage.NewMemoryChallengeStorage(0)
+		t.Cleanup(func() { cs.Close() })
+		return cs
+	})
+}
+
+func TestMemorySecretStorage(t *testing.T) {
+	storagetest.TestSecretStorage(t, func(t *testing.T) storage.SecretStorage {
+		return storage.NewMemorySecretStorage()
+	})
+}
+
+func TestMemorySiteStorage(t *testing.T) {
+	storagetest.TestSiteStorage(t, func(t *testing.T) storage.SiteStorage {
+		return storage.NewMemorySiteStorage()
+	})
+}
+
+// The timeout decorators must pass every operation through unchanged.
+func TestTimeoutStorage(t *testing.T) {
+	t.Run("Keys", func(t *testing.T) {
+		storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+			return storage.NewTimeoutKeyStorage(storage.NewMemoryKeyStorage(), time.Second)
+		})
+	})
+	t.Run("Challenges", func(t *testing.T) {
+		storagetest.TestChallengeStorage(t, func(t *testing.T) storage.ChallengeStorage {
+			cs := storage.NewTimeoutChallengeStorage(storage.NewMemoryChallengeStorage(0), time.Second)
+			t.Cleanup(func() { cs.Close() })
+			return cs
+		})
+	})
+	t.Run("Secrets", func(t *testing.T) {
+		storagetest.TestSecretStorage(t, func(t *testing.T) storage.SecretStorage {
+			return storage.NewTimeoutSecretStorage(storage.NewMemorySecretStorage(), time.Second)
+		})
+	})
+	t.Run("Sites", func(t *testing.T) {
+		storagetest.TestSiteStorage(t, func(t *testing.T) storage.SiteStorage {
+			return storage.NewTimeoutSiteStorage(storage.NewMemorySiteStorage(), time.Second)
+		})
+	})
+}
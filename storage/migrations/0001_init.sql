@@ -0,0 +1,16 @@
+CREATE TABLE ucaptcha_keys (
+    id    TEXT PRIMARY KEY,
+    type  TEXT NOT NULL,
+    state TEXT NOT NULL,
+    data  TEXT NOT NULL
+);
+
+CREATE INDEX ucaptcha_keys_type_state ON ucaptcha_keys (type, state);
+
+CREATE TABLE ucaptcha_challenges (
+    id           TEXT PRIMARY KEY,
+    data         TEXT NOT NULL,
+    delete_after BIGINT NOT NULL
+);
+
+CREATE INDEX ucaptcha_challenges_delete_after ON ucaptcha_challenges (delete_after);
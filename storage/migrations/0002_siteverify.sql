@@ -0,0 +1,12 @@
+CREATE TABLE ucaptcha_redemptions (
+    id           TEXT PRIMARY KEY,
+    delete_after BIGINT NOT NULL
+);
+
+CREATE INDEX ucaptcha_redemptions_delete_after ON ucaptcha_redemptions (delete_after);
+
+CREATE TABLE ucaptcha_site_secrets (
+    hash TEXT PRIMARY KEY,
+    site TEXT NOT NULL,
+    data TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE ucaptcha_sites (
+    site_key TEXT PRIMARY KEY,
+    data     TEXT NOT NULL
+);
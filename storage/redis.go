@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// DefaultRedisPrefix namespaces the Redis keys of a deployment when no prefix is configured.
+const DefaultRedisPrefix = "ucaptcha:"
+
+// NewRedisClient creates the Redis client used by the Redis storages. It
+// connects through Sentinel if a master name is configured, to a Redis
+// Cluster if cluster addresses are configured, and to the single server at
+// Addr otherwise.
+func NewRedisClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := redisTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.Sentinel.MasterName != "" && len(cfg.Cluster.Addrs) > 0:
+		return nil, fmt.Errorf("redis sentinel and cluster cannot be used together")
+	case cfg.Sentinel.MasterName != "":
+		if len(cfg.Sentinel.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel requires at least one sentinel address")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.Sentinel.MasterName,
+			SentinelAddrs:    cfg.Sentinel.Addrs,
+			SentinelUsername: cfg.Sentinel.Username,
+			SentinelPassword: cfg.Sentinel.Password,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case len(cfg.Cluster.Addrs) > 0:
+		if cfg.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports database 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Cluster.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
+		}), nil
+	}
+}
+
+// redisTLSConfig builds the TLS configuration of the Redis client, or nil
+// if TLS is disabled.
+func redisTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// redisPrefix returns the configured key prefix, or DefaultRedisPrefix.
+func redisPrefix(cfg config.RedisConfig) string {
+	if cfg.Prefix == "" {
+		return DefaultRedisPrefix
+	}
+	return cfg.Prefix
+}
+
+// scanKeys calls fn with every key matching pattern. A cluster is scanned
+// on each of its masters, as SCAN only covers the node it is sent to.
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern string, fn func(key string) error) error {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		iter := client.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	// Masters are scanned concurrently, so calls to fn are serialized
+	var mu sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		iter := node.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	})
+}
+
+// redisNamespace returns the prefix under which a storage keeps its keys.
+// In a cluster, the prefix becomes a hash tag holding the keys of the
+// storage in a single slot, so that they can be updated in one
+// transaction. Outside a cluster it is left as is, which keeps the names
+// of existing keys unchanged.
+func redisNamespace(client redis.UniversalClient, prefix, name string) string {
+	if _, ok := client.(*redis.ClusterClient); ok {
+		return "{" + prefix + name + "}:"
+	}
+	return prefix
+}
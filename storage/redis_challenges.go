@@ -1,53 +1,97 @@
 package storage
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"math/big"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/ucaptcha/backend-go/classgroup"
 	"github.com/ucaptcha/backend-go/config"
 	"github.com/ucaptcha/backend-go/types"
 )
 
 // RedisStorage is a Redis implementation of the ChallengeStorage interface.
 type RedisStorage struct {
-	client *redis.Client
+	client         redis.UniversalClient
+	prefix         string // Prefix of the challenge hashes
+	redeemedPrefix string // Prefix of the redemption records
 }
 
 // NewRedisChallengeStorage creates a new RedisStorage instance.
-func NewRedisChallengeStorage(cfg config.RedisConfig) ChallengeStorage {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
-	return &RedisStorage{client: client}
+func NewRedisChallengeStorage(cfg config.RedisConfig) (ChallengeStorage, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStorage{
+		client:         client,
+		prefix:         redisPrefix(cfg) + "challenge:",
+		redeemedPrefix: redisPrefix(cfg) + "redeemed:",
+	}, nil
 }
 
 // Save stores a challenge in Redis.
-func (s *RedisStorage) Save(ch *types.Challenge) error {
-	ctx := s.client.Context()
-	key := fmt.Sprintf("ucaptcha:challenge:%s", ch.ID)
-	err := s.client.HSet(ctx, key,
+func (s *RedisStorage) Save(ctx context.Context, ch *types.Challenge) error {
+	key := s.prefix + ch.ID
+	fields := []any{
 		"id", ch.ID,
 		"KeyID", ch.KeyID,
-		"g", ch.G.String(),
-		"n", ch.N.String(),
+		"type", ch.Type,
+		"scheme", ch.Scheme,
 		"t", ch.T,
 		"created_at", ch.CreatedAt.Format(time.RFC3339),
-	).Err()
+	}
+	if !ch.ExpiresAt.IsZero() {
+		fields = append(fields, "expires_at", ch.ExpiresAt.Format(time.RFC3339))
+	}
+	if ch.Site != "" {
+		fields = append(fields, "site", ch.Site)
+	}
+	if ch.Hostname != "" {
+		fields = append(fields, "hostname", ch.Hostname)
+	}
+	if ch.Action != "" {
+		fields = append(fields, "action", ch.Action)
+	}
+	// Class group challenges store their base form in place of g
+	if ch.Form != nil {
+		fields = append(fields, "g", ch.Form.String())
+	} else if ch.G != nil {
+		fields = append(fields, "g", ch.G.String())
+	}
+	if ch.N != nil {
+		fields = append(fields, "n", ch.N.String())
+	}
+	if ch.Prefix != nil {
+		fields = append(fields, "prefix", hex.EncodeToString(ch.Prefix))
+	}
+	if ch.Target != nil {
+		fields = append(fields, "target", ch.Target.Text(16))
+	}
+	if ch.Argon2 != nil {
+		fields = append(fields,
+			"argon2_memory", ch.Argon2.Memory,
+			"argon2_time", ch.Argon2.Time,
+			"argon2_threads", ch.Argon2.Threads,
+			"argon2_key_len", ch.Argon2.KeyLen,
+		)
+	}
+	err := s.client.HSet(ctx, key, fields...).Err()
 	if err != nil {
 		return err
 	}
-	// Set an expiry for the challenge (e.g., 5 minutes)
-	return s.client.Expire(ctx, key, 5*time.Minute).Err()
+	// Keep the challenge past its expiry so late verifications can be told apart
+	return s.client.ExpireAt(ctx, key, RetainUntil(ch)).Err()
 }
 
 // Get retrieves a challenge from Redis by its ID.
-func (s *RedisStorage) Get(id string) (*types.Challenge, error) {
-	ctx := s.client.Context()
-	key := fmt.Sprintf("ucaptcha:challenge:%s", id)
+func (s *RedisStorage) Get(ctx context.Context, id string) (*types.Challenge, error) {
+	key := s.prefix + id
 	result, err := s.client.HGetAll(ctx, key).Result()
 	if err != nil {
 		return nil, err
@@ -55,25 +99,131 @@ func (s *RedisStorage) Get(id string) (*types.Challenge, error) {
 	if len(result) == 0 {
 		return nil, fmt.Errorf("challenge not found: %s", id)
 	}
+	return parseChallenge(id, result)
+}
 
-	g, _ := new(big.Int).SetString(result["g"], 10)
-	n, _ := new(big.Int).SetString(result["n"], 10)
+// Take retrieves and deletes a challenge in a single MULTI/EXEC transaction,
+// so that of concurrent calls for one ID only one sees the challenge.
+func (s *RedisStorage) Take(ctx context.Context, id string) (*types.Challenge, error) {
+	key := s.prefix + id
+	var get *redis.StringStringMapCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := get.Val()
+	if len(result) == 0 {
+		return nil, fmt.Errorf("challenge not found: %s", id)
+	}
+	return parseChallenge(id, result)
+}
+
+// GetAll retrieves all challenges stored under the prefix. Challenges that
+// expire or are taken while the keys are scanned are skipped.
+func (s *RedisStorage) GetAll(ctx context.Context) ([]*types.Challenge, error) {
+	var challenges []*types.Challenge
+	err := scanKeys(ctx, s.client, s.prefix+"*", func(key string) error {
+		result, err := s.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		ch, err := parseChallenge(strings.TrimPrefix(key, s.prefix), result)
+		if err != nil {
+			return err
+		}
+		challenges = append(challenges, ch)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error iterating challenges in Redis: %v", err)
+	}
+	return challenges, nil
+}
+
+// parseChallenge decodes a challenge from its Redis hash fields.
+func parseChallenge(id string, result map[string]string) (*types.Challenge, error) {
 	t, _ := new(big.Int).SetString(result["t"], 10)
 	createdAt, _ := time.Parse(time.RFC3339, result["created_at"])
+	expiresAt, _ := time.Parse(time.RFC3339, result["expires_at"]) // Zero if missing
 
-	return &types.Challenge{
+	ch := &types.Challenge{
 		ID:        id,
-		G:         g,
-		N:         n,
+		Type:      result["type"],
+		Scheme:    result["scheme"],
 		T:         t.Int64(),
 		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
 		KeyID:     result["KeyID"],
-	}, nil
+		Site:      result["site"],
+		Hostname:  result["hostname"],
+		Action:    result["action"],
+	}
+	switch ch.Type {
+	case types.PuzzleHashcash:
+		ch.Prefix, _ = hex.DecodeString(result["prefix"])
+		return ch, nil
+	case types.PuzzleArgon2id:
+		ch.Prefix, _ = hex.DecodeString(result["prefix"])
+		ch.Target, _ = new(big.Int).SetString(result["target"], 16)
+		ch.Argon2 = &types.Argon2Params{
+			Memory:  parseUint32(result["argon2_memory"]),
+			Time:    parseUint32(result["argon2_time"]),
+			Threads: uint8(parseUint32(result["argon2_threads"])),
+			KeyLen:  parseUint32(result["argon2_key_len"]),
+		}
+		return ch, nil
+	}
+
+	// Anything else is a VDF challenge, including those stored before puzzle types existed
+	ch.Type = types.PuzzleVDF
+	n, _ := new(big.Int).SetString(result["n"], 10)
+	ch.N = n
+	switch ch.Scheme {
+	case types.SchemeClassGroup:
+		form, err := classgroup.Parse(result["g"], n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base form for challenge %s: %v", id, err)
+		}
+		ch.Form = form
+	case "":
+		ch.Scheme = types.SchemeRSA // Stored before schemes existed
+		fallthrough
+	default:
+		ch.G, _ = new(big.Int).SetString(result["g"], 10)
+	}
+	return ch, nil
+}
+
+// Redeem records the redemption of a pass token with SET NX, expiring the
+// record along with the token.
+func (s *RedisStorage) Redeem(ctx context.Context, id string, until time.Time) (bool, error) {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return false, nil // The token already expired
+	}
+	return s.client.SetNX(ctx, s.redeemedPrefix+id, 1, ttl).Result()
 }
 
 // Delete removes a challenge from Redis by its ID.
-func (s *RedisStorage) Delete(id string) error {
-	ctx := s.client.Context()
-	key := fmt.Sprintf("ucaptcha:challenge:%s", id)
+func (s *RedisStorage) Delete(ctx context.Context, id string) error {
+	key := s.prefix + id
 	return s.client.Del(ctx, key).Err()
 }
+
+// Close closes the Redis client.
+func (s *RedisStorage) Close() error {
+	return s.client.Close()
+}
+
+// parseUint32 parses a decimal field, returning 0 if it is missing or malformed.
+func parseUint32(s string) uint32 {
+	v, _ := strconv.ParseUint(s, 10, 32)
+	return uint32(v)
+}
@@ -1,56 +1,196 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/lib"
 )
 
+const (
+	keyCacheSize = 64              // Number of key pairs cached for challenge issuance
+	keyCacheTTL  = 5 * time.Second // How long a cached key pair is served without re-reading it
+)
+
+// cachedKey is a key pair read from Redis along with the time it was read.
+type cachedKey struct {
+	key      *KeyPair
+	loadedAt time.Time
+}
+
 // RedisKeyStorage is a Redis implementation of the KeyStorage interface.
+//
+// Besides one string per key pair, it maintains a set of all key IDs and,
+// per key type, a set of active key IDs. Random selection draws from the
+// active set with SRANDMEMBER, and recently drawn key pairs are cached
+// locally for keyCacheTTL so that issuing a challenge rarely reads the key.
 type RedisKeyStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 	prefix string // Prefix for Redis keys to avoid collisions
+	index  string // Prefix for the key ID sets
+	cache  *lib.LRU[string, cachedKey]
+
+	beforeRepair func(id string) // Test hook, called while the key is watched
 }
 
-// NewRedisKeyStorage creates a new RedisKeyStorage instance.
-func NewRedisKeyStorage(cfg config.RedisConfig) KeyStorage {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
-	return &RedisKeyStorage{
+// NewRedisKeyStorage creates a new RedisKeyStorage instance and rebuilds its
+// key ID index from the stored keys.
+func NewRedisKeyStorage(ctx context.Context, cfg config.RedisConfig) (KeyStorage, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Key pairs and their index are updated together, so they share a cluster slot
+	namespace := redisNamespace(client, redisPrefix(cfg), "keys")
+	s := &RedisKeyStorage{
 		client: client,
-		prefix: "ucaptcha:key:", // Define a prefix for key storage
+		prefix: namespace + "key:",
+		index:  namespace + "keyindex:",
+		cache:  lib.NewLRU[string, cachedKey](keyCacheSize),
+	}
+	if err := s.RebuildIndex(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to rebuild key index: %v", err)
 	}
+	return s, nil
 }
 
-func (s *RedisKeyStorage) GetKeyCount() (int, error) {
-	ctx := s.client.Context()
-	count, err := s.client.Keys(ctx, s.prefix+"*").Result()
+// allIndex is the set of all key IDs.
+func (s *RedisKeyStorage) allIndex() string {
+	return s.index + "all"
+}
+
+// activeIndex is the set of active key IDs of the given type.
+func (s *RedisKeyStorage) activeIndex(keyType KeyType) string {
+	return s.index + "active:" + string(keyType)
+}
+
+// RebuildIndex brings the key ID sets up to date with keys saved before the
+// index existed or modified outside this storage. The sets stay live: each
+// ID found in the keys or in the index is repaired on its own, under WATCH
+// of its key, so that keys saved by other nodes meanwhile are left intact.
+func (s *RedisKeyStorage) RebuildIndex(ctx context.Context) error {
+	ids := make(map[string]struct{})
+	err := scanKeys(ctx, s.client, s.prefix+"*", func(redisKey string) error {
+		ids[strings.TrimPrefix(redisKey, s.prefix)] = struct{}{}
+		return nil
+	})
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("error iterating keys in Redis: %v", err)
+	}
+
+	// Active sets of every type, including types no longer in use
+	activeSets := make(map[string]struct{})
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeClassGroup, KeyTypeEd25519} {
+		activeSets[s.activeIndex(keyType)] = struct{}{}
+	}
+	err = scanKeys(ctx, s.client, s.index+"active:*", func(set string) error {
+		activeSets[set] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, set := range append(slices.Collect(maps.Keys(activeSets)), s.allIndex()) {
+		members, err := s.client.SMembers(ctx, set).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range members {
+			ids[id] = struct{}{}
+		}
+	}
+
+	for id := range ids {
+		if err := s.repairIndex(ctx, id, activeSets); err != nil {
+			return fmt.Errorf("failed to index key %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// maxIndexRetries bounds the attempts at repairing the index entries of a
+// key that keeps being modified.
+const maxIndexRetries = 10
+
+// repairIndex makes the index entries of one key match the stored key. The
+// update is dropped and retried when the key changes meanwhile; SaveKey
+// and DeleteKey index the key they write themselves.
+func (s *RedisKeyStorage) repairIndex(ctx context.Context, id string, activeSets map[string]struct{}) error {
+	redisKey := s.prefix + id
+	repair := func(tx *redis.Tx) error {
+		var key *KeyPair
+		jsonData, err := tx.Get(ctx, redisKey).Result()
+		if err == nil {
+			key = new(KeyPair)
+			if err := json.Unmarshal([]byte(jsonData), key); err != nil {
+				// Left as is, like GetAllKeys skips it
+				log.Printf("Failed to unmarshal key %s: %v", id, err)
+				return nil
+			}
+		} else if err != redis.Nil {
+			return err
+		}
+		if s.beforeRepair != nil {
+			s.beforeRepair(id)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			active := ""
+			if key != nil {
+				pipe.SAdd(ctx, s.allIndex(), id)
+				if key.GetState() == KeyStateActive {
+					active = s.activeIndex(key.GetType())
+					pipe.SAdd(ctx, active, id)
+				}
+			} else {
+				pipe.SRem(ctx, s.allIndex(), id)
+			}
+			for set := range activeSets {
+				if set != active {
+					pipe.SRem(ctx, set, id)
+				}
+			}
+			return nil
+		})
+		return err
 	}
-	return len(count), nil
+
+	for range maxIndexRetries {
+		err := s.client.Watch(ctx, repair, redisKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("key kept changing")
 }
 
-func (s *RedisKeyStorage) HasKey() (bool, error) {
-	ctx := s.client.Context()
-	iter := s.client.Scan(ctx, 0, s.prefix+"*", 0).Iterator()
-	if iter.Next(ctx) {
-		return true, nil
+func (s *RedisKeyStorage) GetKeyCount(ctx context.Context) (int, error) {
+	count, err := s.client.SCard(ctx, s.allIndex()).Result()
+	if err != nil {
+		return 0, err
 	}
-	if err := iter.Err(); err != nil {
+	return int(count), nil
+}
+
+func (s *RedisKeyStorage) HasKey(ctx context.Context) (bool, error) {
+	count, err := s.GetKeyCount(ctx)
+	if err != nil {
 		return false, err
 	}
-	return false, nil
+	return count > 0, nil
 }
 
-// SaveKey stores a key pair in Redis.
-func (s *RedisKeyStorage) SaveKey(key *KeyPair) error {
-	ctx := s.client.Context()
+// SaveKey stores a key pair in Redis and updates the index to its state.
+func (s *RedisKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
 	redisKey := s.prefix + key.ID
 	if key.ID == "" {
 		return fmt.Errorf("key must have an ID")
@@ -62,48 +202,56 @@ func (s *RedisKeyStorage) SaveKey(key *KeyPair) error {
 		return fmt.Errorf("failed to marshal key pair: %v", err)
 	}
 
-	// Store the JSON string in Redis
-	err = s.client.Set(ctx, redisKey, jsonData, 0).Err() // 0 means no expiration
+	// Store the JSON string and its index entries in one transaction
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisKey, jsonData, 0) // 0 means no expiration
+		pipe.SAdd(ctx, s.allIndex(), key.ID)
+		if key.GetState() == KeyStateActive {
+			pipe.SAdd(ctx, s.activeIndex(key.GetType()), key.ID)
+		} else {
+			pipe.SRem(ctx, s.activeIndex(key.GetType()), key.ID)
+		}
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to save key to Redis: %v", err)
 	}
+	s.cache.Remove(key.ID)
 	return nil
 }
 
-// GetRandomKey retrieves a random key pair from Redis.
-func (s *RedisKeyStorage) GetRandomKey() (*KeyPair, error) {
-	ctx := s.client.Context()
-
-	// Get all keys with our prefix
-	keys, err := s.client.Keys(ctx, s.prefix+"*").Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get keys: %v", err)
-	}
-	if len(keys) == 0 {
-		return nil, nil // No keys exist
-	}
-
-	// Select first key (simple approach - could be made truly random if needed)
-	keyID := keys[0]
+// GetRandomKey retrieves a random active key pair of the given type from Redis.
+// IDs left in the index by keys deleted outside this storage are removed
+// as they are drawn.
+func (s *RedisKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) {
+	for {
+		id, err := s.client.SRandMember(ctx, s.activeIndex(keyType)).Result()
+		if err == redis.Nil {
+			return nil, nil // No active keys of this type
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to select key: %v", err)
+		}
 
-	// Get the key data
-	jsonData, err := s.client.Get(ctx, keyID).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get key data: %v", err)
-	}
+		if cached, ok := s.cache.Get(id); ok && time.Since(cached.loadedAt) < keyCacheTTL {
+			return cached.key, nil
+		}
 
-	var key KeyPair
-	err = json.Unmarshal([]byte(jsonData), &key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal key pair: %v", err)
+		key, err := s.GetKey(ctx, id)
+		if err == nil {
+			s.cache.Add(id, cachedKey{key: key, loadedAt: time.Now()})
+			return key, nil
+		}
+		if exists, existsErr := s.client.Exists(ctx, s.prefix+id).Result(); existsErr != nil || exists > 0 {
+			return nil, err
+		}
+		if err := s.client.SRem(ctx, s.activeIndex(keyType), id).Err(); err != nil {
+			return nil, fmt.Errorf("failed to remove stale key %s from index: %v", id, err)
+		}
 	}
-
-	return &key, nil
 }
 
 // GetKey retrieves a key pair from Redis by its ID.
-func (s *RedisKeyStorage) GetKey(id string) (*KeyPair, error) {
-	ctx := s.client.Context()
+func (s *RedisKeyStorage) GetKey(ctx context.Context, id string) (*KeyPair, error) {
 	redisKey := s.prefix + id
 
 	jsonData, err := s.client.Get(ctx, redisKey).Result()
@@ -122,38 +270,50 @@ func (s *RedisKeyStorage) GetKey(id string) (*KeyPair, error) {
 	return &key, nil
 }
 
-// DeleteKey removes a key pair from Redis by its ID.
-func (s *RedisKeyStorage) DeleteKey(id string) error {
-	ctx := s.client.Context()
+// DeleteKey removes a key pair from Redis and the index by its ID.
+func (s *RedisKeyStorage) DeleteKey(ctx context.Context, id string) error {
 	redisKey := s.prefix + id
-	return s.client.Del(ctx, redisKey).Err()
+
+	// The key type selects the active set to remove the ID from
+	key, err := s.GetKey(ctx, id)
+	if err != nil {
+		key = nil // Already gone, only the index may remain
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisKey)
+		pipe.SRem(ctx, s.allIndex(), id)
+		if key != nil {
+			pipe.SRem(ctx, s.activeIndex(key.GetType()), id)
+		}
+		return nil
+	})
+	s.cache.Remove(id)
+	return err
 }
 
 // GetAllKeys retrieves all key pairs currently stored in Redis.
 // Note: This can be inefficient in Redis with many keys. Consider alternatives if performance is critical.
-func (s *RedisKeyStorage) GetAllKeys() ([]*KeyPair, error) {
-	ctx := s.client.Context()
+func (s *RedisKeyStorage) GetAllKeys(ctx context.Context) ([]*KeyPair, error) {
 	var keyList []*KeyPair
 
-	iter := s.client.Scan(ctx, 0, s.prefix+"*", 0).Iterator()
-	for iter.Next(ctx) {
-		redisKey := iter.Val()
+	err := scanKeys(ctx, s.client, s.prefix+"*", func(redisKey string) error {
 		jsonData, err := s.client.Get(ctx, redisKey).Result()
 		if err != nil {
 			// Log error or handle differently? For now, continue to next key.
 			fmt.Printf("Error getting key %s: %v\n", redisKey, err)
-			continue
+			return nil
 		}
 
 		var key KeyPair
 		err = json.Unmarshal([]byte(jsonData), &key)
 		if err != nil {
 			fmt.Printf("Error unmarshalling key %s: %v\n", redisKey, err)
-			continue
+			return nil
 		}
 		keyList = append(keyList, &key)
-	}
-	if err := iter.Err(); err != nil {
+		return nil
+	})
+	if err != nil {
 		return nil, fmt.Errorf("error iterating keys in Redis: %v", err)
 	}
 
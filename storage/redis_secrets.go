@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// RedisSecretStorage is a Redis implementation of the SecretStorage
+// interface. Site secrets are stored as JSON strings under their hash.
+type RedisSecretStorage struct {
+	client redis.UniversalClient
+	prefix string // Prefix of the secret strings
+}
+
+// NewRedisSecretStorage creates a new RedisSecretStorage instance.
+func NewRedisSecretStorage(cfg config.RedisConfig) (SecretStorage, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisSecretStorage{
+		client: client,
+		prefix: redisPrefix(cfg) + "secret:",
+	}, nil
+}
+
+// SaveSecret stores a site secret in Redis.
+func (s *RedisSecretStorage) SaveSecret(ctx context.Context, secret *SiteSecret) error {
+	if secret.Hash == "" {
+		return fmt.Errorf("secret must have a hash")
+	}
+	jsonData, err := json.Marshal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %v", err)
+	}
+	return s.client.Set(ctx, s.prefix+secret.Hash, jsonData, 0).Err()
+}
+
+// GetSecret retrieves a site secret from Redis by its hash.
+func (s *RedisSecretStorage) GetSecret(ctx context.Context, hash string) (*SiteSecret, error) {
+	jsonData, err := s.client.Get(ctx, s.prefix+hash).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("secret not found: %s", hash)
+	} else if err != nil {
+		return nil, err
+	}
+	return unmarshalSecret(jsonData)
+}
+
+// DeleteSecret removes a site secret from Redis by its hash.
+func (s *RedisSecretStorage) DeleteSecret(ctx context.Context, hash string) error {
+	return s.client.Del(ctx, s.prefix+hash).Err()
+}
+
+// GetAllSecrets retrieves all site secrets stored under the prefix.
+func (s *RedisSecretStorage) GetAllSecrets(ctx context.Context) ([]*SiteSecret, error) {
+	var secrets []*SiteSecret
+	err := scanKeys(ctx, s.client, s.prefix+"*", func(key string) error {
+		jsonData, err := s.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil // Deleted while scanning
+		} else if err != nil {
+			return err
+		}
+		secret, err := unmarshalSecret(jsonData)
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error iterating secrets in Redis: %v", err)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ucaptcha/backend-go/config"
+)
+
+// RedisSiteStorage is a Redis implementation of the SiteStorage interface.
+// Sites are stored as JSON strings under their key.
+type RedisSiteStorage struct {
+	client redis.UniversalClient
+	prefix string // Prefix of the site strings
+}
+
+// NewRedisSiteStorage creates a new RedisSiteStorage instance.
+func NewRedisSiteStorage(cfg config.RedisConfig) (SiteStorage, error) {
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisSiteStorage{
+		client: client,
+		prefix: redisPrefix(cfg) + "site:",
+	}, nil
+}
+
+// SaveSite stores a site in Redis.
+func (s *RedisSiteStorage) SaveSite(ctx context.Context, site *Site) error {
+	if site.Key == "" {
+		return fmt.Errorf("site must have a key")
+	}
+	jsonData, err := json.Marshal(site)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site: %v", err)
+	}
+	return s.client.Set(ctx, s.prefix+site.Key, jsonData, 0).Err()
+}
+
+// GetSite retrieves a site from Redis by its key.
+func (s *RedisSiteStorage) GetSite(ctx context.Context, key string) (*Site, error) {
+	jsonData, err := s.client.Get(ctx, s.prefix+key).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("site not found: %s", key)
+	} else if err != nil {
+		return nil, err
+	}
+	return unmarshalSite(jsonData)
+}
+
+// GetAllSites retrieves all sites stored under the prefix.
+func (s *RedisSiteStorage) GetAllSites(ctx context.Context) ([]*Site, error) {
+	var sites []*Site
+	err := scanKeys(ctx, s.client, s.prefix+"*", func(key string) error {
+		jsonData, err := s.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		site, err := unmarshalSite(jsonData)
+		if err != nil {
+			return err
+		}
+		sites = append(sites, site)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error iterating sites in Redis: %v", err)
+	}
+	return sites, nil
+}
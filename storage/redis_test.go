@@ -0,0 +1,177 @@
+package storage_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/storage/storagetest"
+)
+
+// newMiniredis starts an in-process Redis server for one test.
+func newMiniredis(t *testing.T) config.RedisConfig {
+	mr := miniredis.RunT(t)
+	return config.RedisConfig{Addr: mr.Addr()}
+}
+
+func TestRedisKeyStorage(t *testing.T) {
+	storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+		ks, err := storage.NewRedisKeyStorage(t.Context(), newMiniredis(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ks
+	})
+}
+
+func TestRedisChallengeStorage(t *testing.T) {
+	storagetest.TestChallengeStorage(t, func(t *testing.T) storage.ChallengeStorage {
+		cs, err := storage.NewRedisChallengeStorage(newMiniredis(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cs.Close() })
+		return cs
+	})
+}
+
+func TestRedisSecretStorage(t *testing.T) {
+	storagetest.TestSecretStorage(t, func(t *testing.T) storage.SecretStorage {
+		ss, err := storage.NewRedisSecretStorage(newMiniredis(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss
+	})
+}
+
+func TestRedisSiteStorage(t *testing.T) {
+	storagetest.TestSiteStorage(t, func(t *testing.T) storage.SiteStorage {
+		ss, err := storage.NewRedisSiteStorage(newMiniredis(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ss
+	})
+}
+
+// Storages with distinct prefixes must not see each other's keys.
+func TestRedisPrefix(t *testing.T) {
+	cfg := newMiniredis(t)
+	a, err := storage.NewRedisKeyStorage(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Prefix = "other:"
+	b, err := storage.NewRedisKeyStorage(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SaveKey(t.Context(), &storage.KeyPair{ID: "k1"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.GetKeyCount(t.Context()); err != nil || n != 0 {
+		t.Fatalf("storage with another prefix counts %d keys (err %v), want 0", n, err)
+	}
+	if n, err := a.GetKeyCount(t.Context()); err != nil || n != 1 {
+		t.Fatalf("storage counts %d keys (err %v), want 1", n, err)
+	}
+}
+
+// Keys saved by another node while the index is rebuilt keep their entries.
+func TestRedisRebuildIndexConcurrentSave(t *testing.T) {
+	ctx := t.Context()
+	cfg := newMiniredis(t)
+	ks, err := storage.NewRedisKeyStorage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := storage.NewRedisKeyStorage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SaveKey(ctx, &storage.KeyPair{ID: "old", State: storage.KeyStateActive}); err != nil {
+		t.Fatal(err)
+	}
+
+	// While "old" is read, the other node adds a key and deactivates "old"
+	rs := ks.(*storage.RedisKeyStorage)
+	saved := false
+	rs.SetBeforeRepair(func(id string) {
+		if saved || id != "old" {
+			return
+		}
+		saved = true
+		if err := other.SaveKey(ctx, &storage.KeyPair{ID: "new", State: storage.KeyStateActive}); err != nil {
+			t.Error(err)
+		}
+		if err := other.SaveKey(ctx, &storage.KeyPair{ID: "old", State: storage.KeyStateVerifyOnly}); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := rs.RebuildIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !saved {
+		t.Fatal("rebuild never read the stored key")
+	}
+
+	if n, err := ks.GetKeyCount(ctx); err != nil || n != 2 {
+		t.Fatalf("key count after rebuild = %d (err %v), want 2", n, err)
+	}
+	for range 20 {
+		key, err := ks.GetRandomKey(ctx, storage.KeyTypeRSA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == nil || key.ID != "new" {
+			t.Fatalf("random active key after rebuild = %v, want the key saved during the rebuild", key)
+		}
+	}
+}
+
+// Rebuilding adds missing entries and removes stale ones.
+func TestRedisRebuildIndexRepairs(t *testing.T) {
+	ctx := t.Context()
+	mr := miniredis.RunT(t)
+	cfg := config.RedisConfig{Addr: mr.Addr()}
+	ks, err := storage.NewRedisKeyStorage(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*storage.KeyPair{
+		{ID: "active", State: storage.KeyStateActive},
+		{ID: "retired", State: storage.KeyStateRetired},
+	} {
+		if err := ks.SaveKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupt the index: forget an active key and list missing or retired ones
+	var all, active string
+	for _, key := range mr.Keys() {
+		switch {
+		case strings.HasSuffix(key, "keyindex:all"):
+			all = key
+		case strings.HasSuffix(key, "keyindex:active:rsa"):
+			active = key
+		}
+	}
+	mr.SRem(active, "active")
+	mr.SAdd(active, "retired", "gone")
+	mr.SAdd(all, "gone")
+
+	if err := ks.(*storage.RedisKeyStorage).RebuildIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := mr.Members(all); !slices.Equal(members, []string{"active", "retired"}) {
+		t.Errorf("all keys after rebuild = %v, want [active retired]", members)
+	}
+	if members, _ := mr.Members(active); !slices.Equal(members, []string{"active"}) {
+		t.Errorf("active keys after rebuild = %v, want [active]", members)
+	}
+}
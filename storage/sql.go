@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" driver
+	"github.com/ucaptcha/backend-go/config"
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// Supported SQL drivers.
+const (
+	SQLDriverSQLite   = "sqlite"
+	SQLDriverPostgres = "postgres"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// SQLDB is a database handle shared by the SQL storages.
+type SQLDB struct {
+	*sql.DB
+	driver string
+}
+
+// OpenSQL connects to the configured database and applies any pending
+// schema migrations.
+func OpenSQL(ctx context.Context, cfg config.SQLConfig) (*SQLDB, error) {
+	driverName := ""
+	switch cfg.Driver {
+	case SQLDriverSQLite, "":
+		cfg.Driver = SQLDriverSQLite
+		driverName = "sqlite"
+	case SQLDriverPostgres:
+		driverName = "pgx"
+	default:
+		return nil, fmt.Errorf("unsupported SQL driver: %s", cfg.Driver)
+	}
+
+	db, err := sql.Open(driverName, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver == SQLDriverSQLite {
+		// SQLite allows a single writer; serializing avoids "database is locked" errors
+		db.SetMaxOpenConns(1)
+	}
+	s := &SQLDB{DB: db, driver: cfg.Driver}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	return s, nil
+}
+
+// rebind rewrites ? placeholders to the $n form used by Postgres.
+func (s *SQLDB) rebind(query string) string {
+	if s.driver != SQLDriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// migrate applies the embedded migrations that have not been applied yet,
+// in file name order, each in its own transaction.
+func (s *SQLDB) migrate(ctx context.Context) error {
+	_, err := s.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS ucaptcha_schema_migrations (version TEXT PRIMARY KEY)")
+	if err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+		var applied int
+		err := s.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM ucaptcha_schema_migrations WHERE version = ?"), version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrations.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return err
+		}
+		if err := s.applyMigration(ctx, version, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the statements of one migration script and records it.
+func (s *SQLDB) applyMigration(ctx context.Context, version, script string) error {
+	tx, err := s.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range strings.Split(script, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO ucaptcha_schema_migrations (version) VALUES (?)"), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
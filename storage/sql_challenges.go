@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// SQLChallengeStorage is a SQL implementation of the ChallengeStorage interface.
+// Challenges are stored as JSON along with the time they may be dropped,
+// and a background janitor deletes them past that time. Redemption records
+// are kept the same way.
+type SQLChallengeStorage struct {
+	db        *SQLDB
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSQLChallengeStorage creates a new SQLChallengeStorage instance.
+func NewSQLChallengeStorage(db *SQLDB) ChallengeStorage {
+	s := &SQLChallengeStorage{
+		db:   db,
+		done: make(chan struct{}),
+	}
+	go s.janitor()
+	return s
+}
+
+// janitor periodically deletes challenges that are past retention and
+// redemption records whose token expired.
+func (s *SQLChallengeStorage) janitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			// Failures are retried on the next tick; stale rows are never returned
+			s.db.ExecContext(context.Background(), s.db.rebind("DELETE FROM ucaptcha_challenges WHERE delete_after <= ?"), now.UnixMilli())
+			s.db.ExecContext(context.Background(), s.db.rebind("DELETE FROM ucaptcha_redemptions WHERE delete_after <= ?"), now.UnixMilli())
+		}
+	}
+}
+
+// Save stores a challenge.
+func (s *SQLChallengeStorage) Save(ctx context.Context, ch *types.Challenge) error {
+	jsonData, err := json.Marshal(ch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge: %v", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO ucaptcha_challenges (id, data, delete_after) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, delete_after = excluded.delete_after`),
+		ch.ID, string(jsonData), RetainUntil(ch).UnixMilli())
+	return err
+}
+
+// Get retrieves a challenge by its ID.
+func (s *SQLChallengeStorage) Get(ctx context.Context, id string) (*types.Challenge, error) {
+	row := s.db.QueryRowContext(ctx, s.db.rebind("SELECT data FROM ucaptcha_challenges WHERE id = ? AND delete_after > ?"),
+		id, time.Now().UnixMilli())
+	return scanChallenge(id, row)
+}
+
+// Take retrieves and deletes a challenge in a single DELETE ... RETURNING statement.
+func (s *SQLChallengeStorage) Take(ctx context.Context, id string) (*types.Challenge, error) {
+	row := s.db.QueryRowContext(ctx, s.db.rebind("DELETE FROM ucaptcha_challenges WHERE id = ? AND delete_after > ? RETURNING data"),
+		id, time.Now().UnixMilli())
+	return scanChallenge(id, row)
+}
+
+// GetAll retrieves all challenges that are not past retention.
+func (s *SQLChallengeStorage) GetAll(ctx context.Context) ([]*types.Challenge, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.rebind("SELECT id, data FROM ucaptcha_challenges WHERE delete_after > ?"), time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var challenges []*types.Challenge
+	for rows.Next() {
+		var id, jsonData string
+		if err := rows.Scan(&id, &jsonData); err != nil {
+			return nil, err
+		}
+		var ch types.Challenge
+		if err := json.Unmarshal([]byte(jsonData), &ch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal challenge %s: %v", id, err)
+		}
+		challenges = append(challenges, &ch)
+	}
+	return challenges, rows.Err()
+}
+
+// Redeem records the redemption of a pass token. The insert only replaces a
+// record whose token expired, so the single affected row tells whether this
+// call redeemed the token.
+func (s *SQLChallengeStorage) Redeem(ctx context.Context, id string, until time.Time) (bool, error) {
+	now := time.Now()
+	if !until.After(now) {
+		return false, nil // The token already expired
+	}
+	result, err := s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO ucaptcha_redemptions (id, delete_after) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET delete_after = excluded.delete_after WHERE ucaptcha_redemptions.delete_after <= ?`),
+		id, until.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Delete removes a challenge by its ID.
+func (s *SQLChallengeStorage) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM ucaptcha_challenges WHERE id = ?"), id)
+	return err
+}
+
+// Close stops the janitor. The database itself is closed by its owner.
+func (s *SQLChallengeStorage) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// scanChallenge decodes the challenge JSON of a single-row result.
+func scanChallenge(id string, row *sql.Row) (*types.Challenge, error) {
+	var jsonData string
+	err := row.Scan(&jsonData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("challenge not found: %s", id)
+	} else if err != nil {
+		return nil, err
+	}
+	var ch types.Challenge
+	if err := json.Unmarshal([]byte(jsonData), &ch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal challenge %s: %v", id, err)
+	}
+	return &ch, nil
+}
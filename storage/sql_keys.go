@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SQLKeyStorage is a SQL implementation of the KeyStorage interface.
+// Key pairs are stored as JSON, next to their type and state for selection.
+type SQLKeyStorage struct {
+	db *SQLDB
+}
+
+// NewSQLKeyStorage creates a new SQLKeyStorage instance.
+func NewSQLKeyStorage(db *SQLDB) KeyStorage {
+	return &SQLKeyStorage{db: db}
+}
+
+func (s *SQLKeyStorage) GetKeyCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ucaptcha_keys").Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SQLKeyStorage) HasKey(ctx context.Context) (bool, error) {
+	count, err := s.GetKeyCount(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// SaveKey inserts or replaces a key pair.
+func (s *SQLKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
+	if key.ID == "" {
+		return fmt.Errorf("key must have an ID")
+	}
+	jsonData, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key pair: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO ucaptcha_keys (id, type, state, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET type = excluded.type, state = excluded.state, data = excluded.data`),
+		key.ID, string(key.GetType()), string(key.GetState()), string(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to save key to database: %v", err)
+	}
+	return nil
+}
+
+// GetRandomKey retrieves a random active key pair of the given type.
+func (s *SQLKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) {
+	var jsonData string
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT data FROM ucaptcha_keys WHERE type = ? AND state = ? ORDER BY RANDOM() LIMIT 1"),
+		string(keyType), string(KeyStateActive)).Scan(&jsonData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to select key: %v", err)
+	}
+	return unmarshalKeyPair(jsonData)
+}
+
+// GetKey retrieves a key pair by its ID.
+func (s *SQLKeyStorage) GetKey(ctx context.Context, id string) (*KeyPair, error) {
+	var jsonData string
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT data FROM ucaptcha_keys WHERE id = ?"), id).Scan(&jsonData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("key not found: %s", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get key from database: %v", err)
+	}
+	return unmarshalKeyPair(jsonData)
+}
+
+// DeleteKey removes a key pair by its ID.
+func (s *SQLKeyStorage) DeleteKey(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM ucaptcha_keys WHERE id = ?"), id)
+	return err
+}
+
+// GetAllKeys retrieves all stored key pairs.
+func (s *SQLKeyStorage) GetAllKeys(ctx context.Context) ([]*KeyPair, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM ucaptcha_keys")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keyList []*KeyPair
+	for rows.Next() {
+		var jsonData string
+		if err := rows.Scan(&jsonData); err != nil {
+			return nil, err
+		}
+		key, err := unmarshalKeyPair(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		keyList = append(keyList, key)
+	}
+	return keyList, rows.Err()
+}
+
+// unmarshalKeyPair decodes a key pair stored as JSON.
+func unmarshalKeyPair(jsonData string) (*KeyPair, error) {
+	var key KeyPair
+	if err := json.Unmarshal([]byte(jsonData), &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key pair: %v", err)
+	}
+	return &key, nil
+}
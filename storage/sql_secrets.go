@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SQLSecretStorage is a SQL implementation of the SecretStorage interface.
+// Site secrets are stored as JSON under their hash.
+type SQLSecretStorage struct {
+	db *SQLDB
+}
+
+// NewSQLSecretStorage creates a new SQLSecretStorage instance.
+func NewSQLSecretStorage(db *SQLDB) SecretStorage {
+	return &SQLSecretStorage{db: db}
+}
+
+// SaveSecret inserts or replaces a site secret.
+func (s *SQLSecretStorage) SaveSecret(ctx context.Context, secret *SiteSecret) error {
+	if secret.Hash == "" {
+		return fmt.Errorf("secret must have a hash")
+	}
+	jsonData, err := json.Marshal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %v", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO ucaptcha_site_secrets (hash, site, data) VALUES (?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET site = excluded.site, data = excluded.data`),
+		secret.Hash, secret.Site, string(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to save secret to database: %v", err)
+	}
+	return nil
+}
+
+// GetSecret retrieves a site secret by its hash.
+func (s *SQLSecretStorage) GetSecret(ctx context.Context, hash string) (*SiteSecret, error) {
+	var jsonData string
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT data FROM ucaptcha_site_secrets WHERE hash = ?"), hash).Scan(&jsonData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("secret not found: %s", hash)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get secret from database: %v", err)
+	}
+	return unmarshalSecret(jsonData)
+}
+
+// DeleteSecret removes a site secret by its hash.
+func (s *SQLSecretStorage) DeleteSecret(ctx context.Context, hash string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind("DELETE FROM ucaptcha_site_secrets WHERE hash = ?"), hash)
+	return err
+}
+
+// GetAllSecrets retrieves all stored site secrets.
+func (s *SQLSecretStorage) GetAllSecrets(ctx context.Context) ([]*SiteSecret, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM ucaptcha_site_secrets")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []*SiteSecret
+	for rows.Next() {
+		var jsonData string
+		if err := rows.Scan(&jsonData); err != nil {
+			return nil, err
+		}
+		secret, err := unmarshalSecret(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+// unmarshalSecret decodes a site secret stored as JSON.
+func unmarshalSecret(jsonData string) (*SiteSecret, error) {
+	var secret SiteSecret
+	if err := json.Unmarshal([]byte(jsonData), &secret); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret: %v", err)
+	}
+	return &secret, nil
+}
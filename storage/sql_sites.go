@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SQLSiteStorage is a SQL implementation of the SiteStorage interface.
+// Sites are stored as JSON under their key.
+type SQLSiteStorage struct {
+	db *SQLDB
+}
+
+// NewSQLSiteStorage creates a new SQLSiteStorage instance.
+func NewSQLSiteStorage(db *SQLDB) SiteStorage {
+	return &SQLSiteStorage{db: db}
+}
+
+// SaveSite inserts or replaces a site.
+func (s *SQLSiteStorage) SaveSite(ctx context.Context, site *Site) error {
+	if site.Key == "" {
+		return fmt.Errorf("site must have a key")
+	}
+	jsonData, err := json.Marshal(site)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site: %v", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.db.rebind(`INSERT INTO ucaptcha_sites (site_key, data) VALUES (?, ?)
+		ON CONFLICT (site_key) DO UPDATE SET data = excluded.data`),
+		site.Key, string(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to save site to database: %v", err)
+	}
+	return nil
+}
+
+// GetSite retrieves a site by its key.
+func (s *SQLSiteStorage) GetSite(ctx context.Context, key string) (*Site, error) {
+	var jsonData string
+	err := s.db.QueryRowContext(ctx, s.db.rebind("SELECT data FROM ucaptcha_sites WHERE site_key = ?"), key).Scan(&jsonData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("site not found: %s", key)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get site from database: %v", err)
+	}
+	return unmarshalSite(jsonData)
+}
+
+// GetAllSites retrieves all stored sites.
+func (s *SQLSiteStorage) GetAllSites(ctx context.Context) ([]*Site, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM ucaptcha_sites")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []*Site
+	for rows.Next() {
+		var jsonData string
+		if err := rows.Scan(&jsonData); err != nil {
+			return nil, err
+		}
+		site, err := unmarshalSite(jsonData)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// unmarshalSite decodes a site stored as JSON.
+func unmarshalSite(jsonData string) (*Site, error) {
+	var site Site
+	if err := json.Unmarshal([]byte(jsonData), &site); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal site: %v", err)
+	}
+	return &site, nil
+}
@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ucaptcha/backend-go/config"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/storage/storagetest"
+)
+
+// openSQL opens an SQLite database in a temporary directory. The DSN is a
+// plain path: as a "file:" URI, the '#' of subtest directories would start
+// a fragment.
+func openSQL(t *testing.T) *storage.SQLDB {
+	db, err := storage.OpenSQL(t.Context(), config.SQLConfig{DSN: filepath.Join(t.TempDir(), "ucaptcha.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLKeyStorage(t *testing.T) {
+	storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+		return storage.NewSQLKeyStorage(openSQL(t))
+	})
+}
+
+func TestSQLChallengeStorage(t *testing.T) {
+	storagetest.TestChallengeStorage(t, func(t *testing.T) storage.ChallengeStorage {
+		cs := storage.NewSQLChallengeStorage(openSQL(t))
+		t.Cleanup(func() { cs.Close() })
+		return cs
+	})
+}
+
+func TestSQLSecretStorage(t *testing.T) {
+	storagetest.TestSecretStorage(t, func(t *testing.T) storage.SecretStorage {
+		return storage.NewSQLSecretStorage(openSQL(t))
+	})
+}
+
+func TestSQLSiteStorage(t *testing.T) {
+	storagetest.TestSiteStorage(t, func(t *testing.T) storage.SiteStorage {
+		return storage.NewSQLSiteStorage(openSQL(t))
+	})
+}
@@ -1,17 +1,49 @@
 package storage
 
 import (
+	"context"
 	"math/big"
 	"time"
 
 	"github.com/ucaptcha/backend-go/types"
 )
 
+// ChallengeExpiry is how long challenges remain valid after being issued
+// unless configured otherwise.
+const ChallengeExpiry = 5 * time.Minute
+
+// ExpiredRetention is how long storages keep a challenge past its expiry,
+// so that late verifications are told it expired rather than not found.
+const ExpiredRetention = 5 * time.Minute
+
 // ChallengeStorage defines the interface for challenge storage operations.
+// Storages drop challenges once ExpiredRetention has passed since ExpiresAt.
 type ChallengeStorage interface {
-	Save(ch *types.Challenge) error
-	Get(id string) (*types.Challenge, error)
-	Delete(id string) error
+	Save(ctx context.Context, ch *types.Challenge) error
+	Get(ctx context.Context, id string) (*types.Challenge, error)
+	Delete(ctx context.Context, id string) error
+	// Take atomically retrieves and deletes a challenge, so that of
+	// concurrent calls for one ID at most one succeeds.
+	Take(ctx context.Context, id string) (*types.Challenge, error)
+	// GetAll retrieves all stored challenges that are not past retention.
+	GetAll(ctx context.Context) ([]*types.Challenge, error)
+	// Redeem records that the pass token of challenge id was redeemed, and
+	// reports whether this is the first redemption. The record is kept
+	// until the token expires at until, and tokens already expired are never
+	// redeemed. Of concurrent calls for one ID at most one returns true.
+	Redeem(ctx context.Context, id string, until time.Time) (bool, error)
+	// Close releases the resources held by the storage.
+	Close() error
+}
+
+// RetainUntil returns the time after which a storage may drop ch.
+// Challenges without an expiry get the default lifetime.
+func RetainUntil(ch *types.Challenge) time.Time {
+	expiresAt := ch.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = ch.CreatedAt.Add(ChallengeExpiry)
+	}
+	return expiresAt.Add(ExpiredRetention)
 }
 
 // RSAComponents holds the components of an RSA key pair
@@ -21,20 +53,104 @@ type RSAComponents struct {
 	N *big.Int `json:"n"`
 }
 
-// KeyPair represents an RSA key pair with metadata
+// Ed25519Components holds an Ed25519 key pair
+type Ed25519Components struct {
+	Public  []byte `json:"public"`
+	Private []byte `json:"private,omitempty"` // Seed of the private key
+}
+
+// KeyType distinguishes the kinds of key material held in a KeyStorage.
+type KeyType string
+
+const (
+	KeyTypeRSA        KeyType = "rsa"         // RSA modulus with its factorization
+	KeyTypeClassGroup KeyType = "class_group" // Class group discriminant, no secret material
+	KeyTypeEd25519    KeyType = "ed25519"     // Ed25519 key pair signing pass tokens
+)
+
+// KeyState is the lifecycle state of a key.
+type KeyState string
+
+const (
+	KeyStateActive     KeyState = "active"      // Used for new challenges and verification
+	KeyStateVerifyOnly KeyState = "verify_only" // No new challenges, still verifies outstanding ones
+	KeyStateRetired    KeyState = "retired"     // Neither issues nor verifies, pending deletion
+)
+
+// KeyPair represents an RSA key pair, a class group discriminant or an
+// Ed25519 key pair with metadata
 type KeyPair struct {
-	ID          string        `json:"id"` // Unique identifier for the key pair
-	Components  RSAComponents `json:"components"`
-	GeneratedAt time.Time     `json:"generated_at"`
+	ID             string             `json:"id"`             // Unique identifier for the key pair
+	Type           KeyType            `json:"type,omitempty"` // Empty for keys stored before key types existed
+	State          KeyState           `json:"state,omitempty"`
+	Components     RSAComponents      `json:"components"`
+	Discriminant   *big.Int           `json:"discriminant,omitempty"`  // Class group keys only
+	Ed25519        *Ed25519Components `json:"ed25519,omitempty"`       // Ed25519 keys only
+	Sealed         []byte             `json:"sealed,omitempty"`        // Encrypted P and Q, see EncryptedKeyStorage
+	MasterKeyID    string             `json:"master_key_id,omitempty"` // Master key that sealed P and Q
+	GeneratedAt    time.Time          `json:"generated_at"`
+	StateChangedAt time.Time          `json:"state_changed_at,omitzero"`
+}
+
+// GetType returns the key type, treating untyped keys as RSA keys.
+func (k *KeyPair) GetType() KeyType {
+	if k.Type == "" {
+		return KeyTypeRSA
+	}
+	return k.Type
+}
+
+// GetState returns the key state, treating keys stored before states existed as active.
+func (k *KeyPair) GetState() KeyState {
+	if k.State == "" {
+		return KeyStateActive
+	}
+	return k.State
 }
 
 // KeyStorage defines the interface for key pair storage operations.
 type KeyStorage interface {
-	SaveKey(key *KeyPair) error
-	GetKey(id string) (*KeyPair, error)
-	DeleteKey(id string) error
-	GetAllKeys() ([]*KeyPair, error)
-	GetKeyCount() (int, error)
-	GetRandomKey() (*KeyPair, error)
-	HasKey() (bool, error)
+	SaveKey(ctx context.Context, key *KeyPair) error
+	GetKey(ctx context.Context, id string) (*KeyPair, error)
+	DeleteKey(ctx context.Context, id string) error
+	GetAllKeys(ctx context.Context) ([]*KeyPair, error)
+	GetKeyCount(ctx context.Context) (int, error)
+	GetRandomKey(ctx context.Context, keyType KeyType) (*KeyPair, error) // Active keys only
+	HasKey(ctx context.Context) (bool, error)
+}
+
+// SiteSecret is a secret a site authenticates with to redeem pass tokens.
+// Only the SHA-256 hash of the secret is stored.
+type SiteSecret struct {
+	Hash      string    `json:"hash"` // Hex-encoded SHA-256 of the secret
+	Site      string    `json:"site"` // Key of the site
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecretStorage defines the interface for site secret storage operations.
+// Secrets are looked up by hash.
+type SecretStorage interface {
+	SaveSecret(ctx context.Context, secret *SiteSecret) error
+	GetSecret(ctx context.Context, hash string) (*SiteSecret, error)
+	DeleteSecret(ctx context.Context, hash string) error
+	GetAllSecrets(ctx context.Context) ([]*SiteSecret, error)
+}
+
+// Site is a tenant issuing challenges: a website or application identified
+// by its public site key. Its secrets are held in SecretStorage.
+type Site struct {
+	Key          string        `json:"key"`
+	Name         string        `json:"name,omitempty"`
+	Hostnames    []string      `json:"hostnames,omitempty"`     // Hostnames challenges may be issued for, any if empty
+	Difficulty   int64         `json:"difficulty,omitempty"`    // Default difficulty of VDF puzzles, 0 for the global default
+	ChallengeTTL time.Duration `json:"challenge_ttl,omitempty"` // Challenge lifetime, 0 for the global default
+	Disabled     bool          `json:"disabled,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// SiteStorage defines the interface for site storage operations.
+type SiteStorage interface {
+	SaveSite(ctx context.Context, site *Site) error
+	GetSite(ctx context.Context, key string) (*Site, error)
+	GetAllSites(ctx context.Context) ([]*Site, error)
 }
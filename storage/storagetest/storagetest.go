@@ -0,0 +1,732 @@
+// Package storagetest checks that KeyStorage, ChallengeStorage,
+// SecretStorage and SiteStorage implementations behave the way the managers
+// using them expect.
+//
+// A backend is checked by calling the suites from a test in its own package,
+// with a constructor returning an empty storage for every subtest:
+//
+//	func TestRedisKeyStorage(t *testing.T) {
+//		storagetest.TestKeyStorage(t, func(t *testing.T) storage.KeyStorage {
+//			mr := miniredis.RunT(t)
+//			ks, err := storage.NewRedisKeyStorage(t.Context(), config.RedisConfig{Addr: mr.Addr()})
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return ks
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ucaptcha/backend-go/classgroup"
+	"github.com/ucaptcha/backend-go/storage"
+	"github.com/ucaptcha/backend-go/types"
+)
+
+const (
+	concurrency     = 16  // Goroutines of the concurrency subtests
+	randomDraws     = 600 // Draws of the random selection distribution subtest
+	randomCandidate = 3   // Eligible keys of the random selection distribution subtest
+)
+
+// TestKeyStorage runs the KeyStorage conformance suite. newStorage must
+// return an empty storage each time it is called.
+func TestKeyStorage(t *testing.T, newStorage func(t *testing.T) storage.KeyStorage) {
+	t.Run("Empty", func(t *testing.T) {
+		ks := newStorage(t)
+		if hasKey, err := ks.HasKey(t.Context()); err != nil || hasKey {
+			t.Fatalf("HasKey() = %v, %v; want false, nil", hasKey, err)
+		}
+		if count, err := ks.GetKeyCount(t.Context()); err != nil || count != 0 {
+			t.Fatalf("GetKeyCount() = %d, %v; want 0, nil", count, err)
+		}
+		// The key manager relies on nil, nil to detect an empty pool
+		if key, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA); err != nil || key != nil {
+			t.Fatalf("GetRandomKey() = %v, %v; want nil, nil", key, err)
+		}
+		if keys, err := ks.GetAllKeys(t.Context()); err != nil || len(keys) != 0 {
+			t.Fatalf("GetAllKeys() = %d keys, %v; want 0, nil", len(keys), err)
+		}
+	})
+
+	t.Run("CRUD", func(t *testing.T) {
+		ks := newStorage(t)
+		key := newKey("k1", storage.KeyTypeRSA, storage.KeyStateActive)
+		mustSaveKey(t, ks, key)
+
+		got, err := ks.GetKey(t.Context(), key.ID)
+		if err != nil {
+			t.Fatalf("GetKey() error: %v", err)
+		}
+		assertKeyEqual(t, got, key)
+		if hasKey, err := ks.HasKey(t.Context()); err != nil || !hasKey {
+			t.Fatalf("HasKey() = %v, %v; want true, nil", hasKey, err)
+		}
+
+		// Saving under an existing ID replaces the key
+		key.State = storage.KeyStateVerifyOnly
+		mustSaveKey(t, ks, key)
+		if got, err = ks.GetKey(t.Context(), key.ID); err != nil {
+			t.Fatalf("GetKey() error: %v", err)
+		}
+		assertKeyEqual(t, got, key)
+		if count, err := ks.GetKeyCount(t.Context()); err != nil || count != 1 {
+			t.Fatalf("GetKeyCount() after overwrite = %d, %v; want 1, nil", count, err)
+		}
+
+		if err := ks.DeleteKey(t.Context(), key.ID); err != nil {
+			t.Fatalf("DeleteKey() error: %v", err)
+		}
+		if _, err := ks.GetKey(t.Context(), key.ID); err == nil {
+			t.Fatal("GetKey() of a deleted key succeeded")
+		}
+		if count, err := ks.GetKeyCount(t.Context()); err != nil || count != 0 {
+			t.Fatalf("GetKeyCount() after delete = %d, %v; want 0, nil", count, err)
+		}
+	})
+
+	t.Run("KeyTypes", func(t *testing.T) {
+		ks := newStorage(t)
+		want := map[string]*storage.KeyPair{}
+		for _, keyType := range []storage.KeyType{storage.KeyTypeRSA, storage.KeyTypeClassGroup, storage.KeyTypeEd25519} {
+			key := newKey(string(keyType), keyType, storage.KeyStateVerifyOnly)
+			mustSaveKey(t, ks, key)
+			want[key.ID] = key
+
+			got, err := ks.GetKey(t.Context(), key.ID)
+			if err != nil {
+				t.Fatalf("GetKey(%s) error: %v", key.ID, err)
+			}
+			assertKeyEqual(t, got, key)
+		}
+
+		all, err := ks.GetAllKeys(t.Context())
+		if err != nil || len(all) != len(want) {
+			t.Fatalf("GetAllKeys() = %d keys, %v; want %d, nil", len(all), err, len(want))
+		}
+		for _, got := range all {
+			key, ok := want[got.ID]
+			if !ok {
+				t.Fatalf("GetAllKeys() returned unexpected key %s", got.ID)
+			}
+			assertKeyEqual(t, got, key)
+		}
+	})
+
+	t.Run("Sealed", func(t *testing.T) {
+		ks := newStorage(t)
+		if _, ok := ks.(*storage.EncryptedKeyStorage); ok {
+			t.Skip("the encrypted storage opens sealed keys itself")
+		}
+		key := newKey("sealed", storage.KeyTypeRSA, storage.KeyStateActive)
+		key.Components.P, key.Components.Q = nil, nil
+		key.Sealed = []byte("nonce and ciphertext")
+		key.MasterKeyID = "mk1"
+		mustSaveKey(t, ks, key)
+
+		got, err := ks.GetKey(t.Context(), key.ID)
+		if err != nil {
+			t.Fatalf("GetKey() error: %v", err)
+		}
+		assertKeyEqual(t, got, key)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		ks := newStorage(t)
+		if key, err := ks.GetKey(t.Context(), "missing"); err == nil {
+			t.Fatalf("GetKey() of a missing key = %v, nil; want an error", key)
+		}
+		if err := ks.DeleteKey(t.Context(), "missing"); err != nil {
+			t.Fatalf("DeleteKey() of a missing key: %v", err)
+		}
+	})
+
+	t.Run("SaveWithoutID", func(t *testing.T) {
+		ks := newStorage(t)
+		if err := ks.SaveKey(t.Context(), newKey("", storage.KeyTypeRSA, storage.KeyStateActive)); err == nil {
+			t.Fatal("SaveKey() of a key without ID succeeded")
+		}
+	})
+
+	t.Run("RandomSelectionFilters", func(t *testing.T) {
+		ks := newStorage(t)
+		mustSaveKey(t, ks, newKey("rsa-verify-only", storage.KeyTypeRSA, storage.KeyStateVerifyOnly))
+		mustSaveKey(t, ks, newKey("rsa-retired", storage.KeyTypeRSA, storage.KeyStateRetired))
+		mustSaveKey(t, ks, newKey("class-group", storage.KeyTypeClassGroup, storage.KeyStateActive))
+		if key, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA); err != nil || key != nil {
+			t.Fatalf("GetRandomKey() without active RSA keys = %v, %v; want nil, nil", key, err)
+		}
+
+		mustSaveKey(t, ks, newKey("rsa-active", storage.KeyTypeRSA, storage.KeyStateActive))
+		for range 20 {
+			key, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA)
+			if err != nil || key == nil || key.ID != "rsa-active" {
+				t.Fatalf("GetRandomKey() = %v, %v; want rsa-active", key, err)
+			}
+		}
+
+		// Deactivating the only active key empties the selection again
+		deactivated := newKey("rsa-active", storage.KeyTypeRSA, storage.KeyStateVerifyOnly)
+		mustSaveKey(t, ks, deactivated)
+		if key, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA); err != nil || key != nil {
+			t.Fatalf("GetRandomKey() after deactivation = %v, %v; want nil, nil", key, err)
+		}
+	})
+
+	t.Run("RandomSelectionDistribution", func(t *testing.T) {
+		ks := newStorage(t)
+		counts := make(map[string]int)
+		for i := range randomCandidate {
+			key := newKey(string(rune('a'+i)), storage.KeyTypeRSA, storage.KeyStateActive)
+			mustSaveKey(t, ks, key)
+			counts[key.ID] = 0
+		}
+		for range randomDraws {
+			key, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA)
+			if err != nil || key == nil {
+				t.Fatalf("GetRandomKey() = %v, %v", key, err)
+			}
+			counts[key.ID]++
+		}
+		// Each key is expected randomDraws/randomCandidate times; half of that
+		// is more than ten standard deviations away.
+		for id, n := range counts {
+			if n < randomDraws/randomCandidate/2 {
+				t.Errorf("key %s selected %d of %d times, selection is not uniform: %v", id, n, randomDraws, counts)
+			}
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		ks := newStorage(t)
+		var wg sync.WaitGroup
+		errs := make(chan error, concurrency*3)
+		for i := range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := newKey(string(rune('A'+i)), storage.KeyTypeRSA, storage.KeyStateActive)
+				if err := ks.SaveKey(t.Context(), key); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := ks.GetKey(t.Context(), key.ID); err != nil {
+					errs <- err
+				}
+				if _, err := ks.GetRandomKey(t.Context(), storage.KeyTypeRSA); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		if count, err := ks.GetKeyCount(t.Context()); err != nil || count != concurrency {
+			t.Fatalf("GetKeyCount() = %d, %v; want %d, nil", count, err, concurrency)
+		}
+	})
+}
+
+// TestChallengeStorage runs the ChallengeStorage conformance suite.
+// newStorage must return an empty storage each time it is called; the suite
+// closes it.
+func TestChallengeStorage(t *testing.T, newStorage func(t *testing.T) storage.ChallengeStorage) {
+	open := func(t *testing.T) storage.ChallengeStorage {
+		cs := newStorage(t)
+		t.Cleanup(func() { cs.Close() })
+		return cs
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		cs := open(t)
+		for _, ch := range []*types.Challenge{
+			newVDFChallenge("vdf"),
+			newClassGroupChallenge("classgroup"),
+			newHashcashChallenge("hashcash"),
+			newArgon2Challenge("argon2id"),
+		} {
+			mustSaveChallenge(t, cs, ch)
+			got, err := cs.Get(t.Context(), ch.ID)
+			if err != nil {
+				t.Fatalf("Get(%s) error: %v", ch.ID, err)
+			}
+			assertChallengeEqual(t, got, ch)
+		}
+
+		// Saving under an existing ID replaces the challenge
+		ch := newVDFChallenge("vdf")
+		ch.T = 42
+		mustSaveChallenge(t, cs, ch)
+		got, err := cs.Get(t.Context(), ch.ID)
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		assertChallengeEqual(t, got, ch)
+
+		if err := cs.Delete(t.Context(), ch.ID); err != nil {
+			t.Fatalf("Delete() error: %v", err)
+		}
+		if _, err := cs.Get(t.Context(), ch.ID); err == nil {
+			t.Fatal("Get() of a deleted challenge succeeded")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		cs := open(t)
+		if ch, err := cs.Get(t.Context(), "missing"); err == nil {
+			t.Fatalf("Get() of a missing challenge = %v, nil; want an error", ch)
+		}
+		if ch, err := cs.Take(t.Context(), "missing"); err == nil {
+			t.Fatalf("Take() of a missing challenge = %v, nil; want an error", ch)
+		}
+		if err := cs.Delete(t.Context(), "missing"); err != nil {
+			t.Fatalf("Delete() of a missing challenge: %v", err)
+		}
+	})
+
+	t.Run("Take", func(t *testing.T) {
+		cs := open(t)
+		ch := newVDFChallenge("take")
+		mustSaveChallenge(t, cs, ch)
+		got, err := cs.Take(t.Context(), ch.ID)
+		if err != nil {
+			t.Fatalf("Take() error: %v", err)
+		}
+		assertChallengeEqual(t, got, ch)
+		if _, err := cs.Take(t.Context(), ch.ID); err == nil {
+			t.Fatal("second Take() succeeded")
+		}
+		if _, err := cs.Get(t.Context(), ch.ID); err == nil {
+			t.Fatal("Get() after Take() succeeded")
+		}
+	})
+
+	t.Run("ConcurrentTake", func(t *testing.T) {
+		cs := open(t)
+		ch := newVDFChallenge("race")
+		mustSaveChallenge(t, cs, ch)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		taken := 0
+		for range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := cs.Take(t.Context(), ch.ID); err == nil {
+					mu.Lock()
+					taken++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if taken != 1 {
+			t.Fatalf("%d of %d concurrent Take() calls succeeded; want exactly 1", taken, concurrency)
+		}
+	})
+
+	t.Run("GetAll", func(t *testing.T) {
+		cs := open(t)
+		all, err := cs.GetAll(t.Context())
+		if err != nil {
+			t.Fatalf("GetAll() error: %v", err)
+		}
+		if len(all) != 0 {
+			t.Fatalf("GetAll() of an empty storage returned %d challenges", len(all))
+		}
+
+		want := map[string]*types.Challenge{}
+		for _, ch := range []*types.Challenge{
+			newVDFChallenge("vdf"),
+			newClassGroupChallenge("classgroup"),
+			newHashcashChallenge("hashcash"),
+			newArgon2Challenge("argon2id"),
+			newVDFChallenge("taken"),
+		} {
+			mustSaveChallenge(t, cs, ch)
+			want[ch.ID] = ch
+		}
+		dropped := newVDFChallenge("dropped")
+		dropped.ExpiresAt = time.Now().Add(-storage.ExpiredRetention - time.Minute)
+		mustSaveChallenge(t, cs, dropped)
+		if _, err := cs.Take(t.Context(), "taken"); err != nil {
+			t.Fatalf("Take() error: %v", err)
+		}
+		delete(want, "taken")
+
+		all, err = cs.GetAll(t.Context())
+		if err != nil {
+			t.Fatalf("GetAll() error: %v", err)
+		}
+		if len(all) != len(want) {
+			t.Fatalf("GetAll() returned %d challenges; want %d", len(all), len(want))
+		}
+		for _, got := range all {
+			ch, ok := want[got.ID]
+			if !ok {
+				t.Fatalf("GetAll() returned unexpected challenge %s", got.ID)
+			}
+			assertChallengeEqual(t, got, ch)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		cs := open(t)
+		now := time.Now()
+
+		// Expired but within retention: still returned, so it can be reported as expired
+		expired := newVDFChallenge("expired")
+		expired.CreatedAt = now.Add(-2 * time.Minute)
+		expired.ExpiresAt = now.Add(-time.Minute)
+		mustSaveChallenge(t, cs, expired)
+		got, err := cs.Get(t.Context(), expired.ID)
+		if err != nil {
+			t.Fatalf("Get() of a challenge within retention: %v", err)
+		}
+		if !got.Expired(now) {
+			t.Fatal("challenge within retention is not reported as expired")
+		}
+
+		// Past retention: gone
+		dropped := newVDFChallenge("dropped")
+		dropped.CreatedAt = now.Add(-2 * storage.ExpiredRetention)
+		dropped.ExpiresAt = now.Add(-storage.ExpiredRetention - time.Minute)
+		mustSaveChallenge(t, cs, dropped)
+		if _, err := cs.Get(t.Context(), dropped.ID); err == nil {
+			t.Fatal("Get() of a challenge past retention succeeded")
+		}
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		cs := open(t)
+		until := time.Now().Add(time.Minute)
+		if redeemed, err := cs.Redeem(t.Context(), "token", until); err != nil || !redeemed {
+			t.Fatalf("first Redeem() = %v, %v; want true, nil", redeemed, err)
+		}
+		if redeemed, err := cs.Redeem(t.Context(), "token", until); err != nil || redeemed {
+			t.Fatalf("second Redeem() = %v, %v; want false, nil", redeemed, err)
+		}
+		// An expired token cannot be redeemed
+		if redeemed, err := cs.Redeem(t.Context(), "expired", time.Now().Add(-time.Second)); err != nil || redeemed {
+			t.Fatalf("Redeem() of an expired token = %v, %v; want false, nil", redeemed, err)
+		}
+		// Redemptions are independent of challenges
+		ch := newVDFChallenge("token")
+		mustSaveChallenge(t, cs, ch)
+		if _, err := cs.Take(t.Context(), ch.ID); err != nil {
+			t.Fatalf("Take() after Redeem() error: %v", err)
+		}
+	})
+
+	t.Run("ConcurrentRedeem", func(t *testing.T) {
+		cs := open(t)
+		until := time.Now().Add(time.Minute)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		redeemed := 0
+		for range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, err := cs.Redeem(t.Context(), "race", until); err == nil && ok {
+					mu.Lock()
+					redeemed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if redeemed != 1 {
+			t.Fatalf("%d of %d concurrent Redeem() calls succeeded; want exactly 1", redeemed, concurrency)
+		}
+	})
+}
+
+// TestSecretStorage runs the SecretStorage conformance suite. newStorage
+// must return an empty storage each time it is called.
+func TestSecretStorage(t *testing.T, newStorage func(t *testing.T) storage.SecretStorage) {
+	t.Run("CRUD", func(t *testing.T) {
+		ss := newStorage(t)
+		if secrets, err := ss.GetAllSecrets(t.Context()); err != nil || len(secrets) != 0 {
+			t.Fatalf("GetAllSecrets() = %d secrets, %v; want 0, nil", len(secrets), err)
+		}
+
+		secret := newSecret("a1", "example.com")
+		mustSaveSecret(t, ss, secret)
+		mustSaveSecret(t, ss, newSecret("b2", "example.org"))
+		got, err := ss.GetSecret(t.Context(), secret.Hash)
+		if err != nil {
+			t.Fatalf("GetSecret() error: %v", err)
+		}
+		assertSecretEqual(t, got, secret)
+
+		// Saving under an existing hash replaces the secret
+		secret.Site = "example.net"
+		mustSaveSecret(t, ss, secret)
+		if got, err = ss.GetSecret(t.Context(), secret.Hash); err != nil {
+			t.Fatalf("GetSecret() error: %v", err)
+		}
+		assertSecretEqual(t, got, secret)
+
+		if secrets, err := ss.GetAllSecrets(t.Context()); err != nil || len(secrets) != 2 {
+			t.Fatalf("GetAllSecrets() = %d secrets, %v; want 2, nil", len(secrets), err)
+		}
+
+		if err := ss.DeleteSecret(t.Context(), secret.Hash); err != nil {
+			t.Fatalf("DeleteSecret() error: %v", err)
+		}
+		if _, err := ss.GetSecret(t.Context(), secret.Hash); err == nil {
+			t.Fatal("GetSecret() of a deleted secret succeeded")
+		}
+		if err := ss.DeleteSecret(t.Context(), secret.Hash); err != nil {
+			t.Fatalf("DeleteSecret() of a missing secret: %v", err)
+		}
+	})
+}
+
+// TestSiteStorage runs the SiteStorage conformance suite. newStorage must
+// return an empty storage each time it is called.
+func TestSiteStorage(t *testing.T, newStorage func(t *testing.T) storage.SiteStorage) {
+	t.Run("CRUD", func(t *testing.T) {
+		ss := newStorage(t)
+		if sites, err := ss.GetAllSites(t.Context()); err != nil || len(sites) != 0 {
+			t.Fatalf("GetAllSites() = %d sites, %v; want 0, nil", len(sites), err)
+		}
+		if site, err := ss.GetSite(t.Context(), "missing"); err == nil {
+			t.Fatalf("GetSite() of a missing site = %v, nil; want an error", site)
+		}
+
+		site := &storage.Site{
+			Key:          "s1",
+			Name:         "Example",
+			Hostnames:    []string{"example.com", "example.org"},
+			Difficulty:   50000,
+			ChallengeTTL: 2 * time.Minute,
+			CreatedAt:    time.Now().Truncate(time.Second),
+		}
+		mustSaveSite(t, ss, site)
+		mustSaveSite(t, ss, &storage.Site{Key: "s2", CreatedAt: time.Now().Truncate(time.Second)})
+		got, err := ss.GetSite(t.Context(), site.Key)
+		if err != nil {
+			t.Fatalf("GetSite() error: %v", err)
+		}
+		assertSiteEqual(t, got, site)
+
+		// Saving under an existing key replaces the site
+		site.Disabled = true
+		site.Hostnames = nil
+		mustSaveSite(t, ss, site)
+		if got, err = ss.GetSite(t.Context(), site.Key); err != nil {
+			t.Fatalf("GetSite() error: %v", err)
+		}
+		assertSiteEqual(t, got, site)
+
+		if sites, err := ss.GetAllSites(t.Context()); err != nil || len(sites) != 2 {
+			t.Fatalf("GetAllSites() = %d sites, %v; want 2, nil", len(sites), err)
+		}
+	})
+}
+
+// newKey returns a key pair with small fixed components of its type.
+func newKey(id string, keyType storage.KeyType, state storage.KeyState) *storage.KeyPair {
+	now := time.Now().Truncate(time.Second)
+	key := &storage.KeyPair{
+		ID:             id,
+		Type:           keyType,
+		State:          state,
+		GeneratedAt:    now.Add(-time.Hour),
+		StateChangedAt: now,
+	}
+	switch keyType {
+	case storage.KeyTypeClassGroup:
+		key.Discriminant = big.NewInt(-1031)
+	case storage.KeyTypeEd25519:
+		key.Ed25519 = &storage.Ed25519Components{
+			Public:  bytes.Repeat([]byte{1}, 32),
+			Private: bytes.Repeat([]byte{2}, 64),
+		}
+	default:
+		key.Components = storage.RSAComponents{P: big.NewInt(1019), Q: big.NewInt(1031), N: big.NewInt(1019 * 1031)}
+	}
+	return key
+}
+
+// newVDFChallenge returns an RSA VDF challenge expiring in a minute.
+func newVDFChallenge(id string) *types.Challenge {
+	now := time.Now().Truncate(time.Second)
+	return &types.Challenge{
+		ID:        id,
+		Type:      types.PuzzleVDF,
+		Scheme:    types.SchemeRSA,
+		G:         big.NewInt(4),
+		N:         big.NewInt(1019 * 1031),
+		T:         1000,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+		KeyID:     "k1",
+	}
+}
+
+// newClassGroupChallenge returns a class group VDF challenge expiring in a
+// minute.
+func newClassGroupChallenge(id string) *types.Challenge {
+	now := time.Now().Truncate(time.Second)
+	d := big.NewInt(-1031)
+	return &types.Challenge{
+		ID:        id,
+		Type:      types.PuzzleVDF,
+		Scheme:    types.SchemeClassGroup,
+		Form:      classgroup.Generator(d),
+		N:         d,
+		T:         1000,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+		KeyID:     "cg",
+	}
+}
+
+// newArgon2Challenge returns an argon2id challenge expiring in a minute.
+func newArgon2Challenge(id string) *types.Challenge {
+	now := time.Now().Truncate(time.Second)
+	return &types.Challenge{
+		ID:        id,
+		Type:      types.PuzzleArgon2id,
+		Prefix:    []byte("fedcba9876543210"),
+		Target:    new(big.Int).Lsh(big.NewInt(1), 248),
+		Argon2:    &types.Argon2Params{Memory: 19456, Time: 2, Threads: 1, KeyLen: 32},
+		T:         8,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+		Site:      "site-key",
+		Hostname:  "example.com",
+		Action:    "signup",
+	}
+}
+
+// newHashcashChallenge returns a hashcash challenge expiring in a minute.
+func newHashcashChallenge(id string) *types.Challenge {
+	now := time.Now().Truncate(time.Second)
+	return &types.Challenge{
+		ID:        id,
+		Type:      types.PuzzleHashcash,
+		Prefix:    []byte("0123456789abcdef"),
+		T:         20,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+		Site:      "site-key",
+		Hostname:  "example.com",
+		Action:    "login",
+	}
+}
+
+// newSecret returns a site secret with the given hash.
+func newSecret(hash, site string) *storage.SiteSecret {
+	return &storage.SiteSecret{Hash: hash, Site: site, CreatedAt: time.Now().Truncate(time.Second)}
+}
+
+func mustSaveKey(t *testing.T, ks storage.KeyStorage, key *storage.KeyPair) {
+	t.Helper()
+	if err := ks.SaveKey(t.Context(), key); err != nil {
+		t.Fatalf("SaveKey(%s) error: %v", key.ID, err)
+	}
+}
+
+func mustSaveChallenge(t *testing.T, cs storage.ChallengeStorage, ch *types.Challenge) {
+	t.Helper()
+	if err := cs.Save(t.Context(), ch); err != nil {
+		t.Fatalf("Save(%s) error: %v", ch.ID, err)
+	}
+}
+
+func mustSaveSecret(t *testing.T, ss storage.SecretStorage, secret *storage.SiteSecret) {
+	t.Helper()
+	if err := ss.SaveSecret(t.Context(), secret); err != nil {
+		t.Fatalf("SaveSecret(%s) error: %v", secret.Hash, err)
+	}
+}
+
+func mustSaveSite(t *testing.T, ss storage.SiteStorage, site *storage.Site) {
+	t.Helper()
+	if err := ss.SaveSite(t.Context(), site); err != nil {
+		t.Fatalf("SaveSite(%s) error: %v", site.Key, err)
+	}
+}
+
+// assertKeyEqual compares the fields every backend must round-trip.
+func assertKeyEqual(t *testing.T, got, want *storage.KeyPair) {
+	t.Helper()
+	if got.ID != want.ID || got.GetType() != want.GetType() || got.GetState() != want.GetState() {
+		t.Fatalf("got key %s (%s, %s), want %s (%s, %s)",
+			got.ID, got.GetType(), got.GetState(), want.ID, want.GetType(), want.GetState())
+	}
+	switch {
+	case !equalInt(got.Components.P, want.Components.P), !equalInt(got.Components.Q, want.Components.Q),
+		!equalInt(got.Components.N, want.Components.N), !equalInt(got.Discriminant, want.Discriminant):
+		t.Fatalf("key %s components were not preserved", want.ID)
+	case (got.Ed25519 == nil) != (want.Ed25519 == nil),
+		want.Ed25519 != nil && (!bytes.Equal(got.Ed25519.Public, want.Ed25519.Public) ||
+			!bytes.Equal(got.Ed25519.Private, want.Ed25519.Private)):
+		t.Fatalf("key %s Ed25519 components were not preserved", want.ID)
+	case !bytes.Equal(got.Sealed, want.Sealed), got.MasterKeyID != want.MasterKeyID:
+		t.Fatalf("key %s sealed secrets were not preserved", want.ID)
+	case !got.GeneratedAt.Equal(want.GeneratedAt), !got.StateChangedAt.Equal(want.StateChangedAt):
+		t.Fatalf("key %s timestamps were not preserved", want.ID)
+	}
+}
+
+// assertChallengeEqual compares the fields every backend must round-trip.
+// Timestamps are compared to the second.
+func assertChallengeEqual(t *testing.T, got, want *types.Challenge) {
+	t.Helper()
+	switch {
+	case got.ID != want.ID, got.Type != want.Type, got.Scheme != want.Scheme,
+		got.T != want.T, got.KeyID != want.KeyID, got.Site != want.Site, got.Hostname != want.Hostname, got.Action != want.Action:
+		t.Fatalf("got challenge %+v, want %+v", got, want)
+	case !equalInt(got.G, want.G), !equalInt(got.N, want.N), !equalInt(got.Target, want.Target),
+		!bytes.Equal(got.Prefix, want.Prefix):
+		t.Fatalf("challenge %s parameters were not preserved", want.ID)
+	case (got.Form == nil) != (want.Form == nil), want.Form != nil && !got.Form.Equal(want.Form):
+		t.Fatalf("challenge %s form was not preserved", want.ID)
+	case (got.Argon2 == nil) != (want.Argon2 == nil), want.Argon2 != nil && *got.Argon2 != *want.Argon2:
+		t.Fatalf("challenge %s argon2id parameters were not preserved", want.ID)
+	case !got.CreatedAt.Truncate(time.Second).Equal(want.CreatedAt.Truncate(time.Second)),
+		!got.ExpiresAt.Truncate(time.Second).Equal(want.ExpiresAt.Truncate(time.Second)):
+		t.Fatalf("challenge %s timestamps were not preserved", want.ID)
+	}
+}
+
+// assertSecretEqual compares the fields every backend must round-trip.
+func assertSecretEqual(t *testing.T, got, want *storage.SiteSecret) {
+	t.Helper()
+	if got.Hash != want.Hash || got.Site != want.Site || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("got secret %+v, want %+v", got, want)
+	}
+}
+
+// assertSiteEqual compares the fields every backend must round-trip.
+func assertSiteEqual(t *testing.T, got, want *storage.Site) {
+	t.Helper()
+	if got.Key != want.Key || got.Name != want.Name || !slices.Equal(got.Hostnames, want.Hostnames) ||
+		got.Difficulty != want.Difficulty || got.ChallengeTTL != want.ChallengeTTL ||
+		got.Disabled != want.Disabled || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("got site %+v, want %+v", got, want)
+	}
+}
+
+func equalInt(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
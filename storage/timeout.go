@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ucaptcha/backend-go/types"
+)
+
+// DefaultOperationTimeout bounds each storage operation when no timeout is configured.
+const DefaultOperationTimeout = 5 * time.Second
+
+// ErrTimeout is returned, wrapped, by storages whose operation ran past its deadline.
+var ErrTimeout = errors.New("storage operation timed out")
+
+// withDeadline runs op under a context that expires after timeout, and
+// reports the failure as ErrTimeout if op failed because of that deadline.
+// Backends surface deadlines differently (context errors, network
+// timeouts), so the context is consulted rather than the error itself.
+func withDeadline(ctx context.Context, timeout time.Duration, op func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := op(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
+
+// TimeoutKeyStorage wraps a KeyStorage and bounds each of its operations
+// with a deadline.
+type TimeoutKeyStorage struct {
+	inner   KeyStorage
+	timeout time.Duration
+}
+
+// NewTimeoutKeyStorage wraps inner with a per-operation timeout, or
+// DefaultOperationTimeout if timeout is not positive.
+func NewTimeoutKeyStorage(inner KeyStorage, timeout time.Duration) KeyStorage {
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return &TimeoutKeyStorage{inner: inner, timeout: timeout}
+}
+
+func (s *TimeoutKeyStorage) SaveKey(ctx context.Context, key *KeyPair) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.SaveKey(ctx, key)
+	})
+}
+
+func (s *TimeoutKeyStorage) GetKey(ctx context.Context, id string) (key *KeyPair, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		key, err = s.inner.GetKey(ctx, id)
+		return err
+	})
+	return key, err
+}
+
+func (s *TimeoutKeyStorage) DeleteKey(ctx context.Context, id string) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.DeleteKey(ctx, id)
+	})
+}
+
+func (s *TimeoutKeyStorage) GetAllKeys(ctx context.Context) (keys []*KeyPair, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		keys, err = s.inner.GetAllKeys(ctx)
+		return err
+	})
+	return keys, err
+}
+
+func (s *TimeoutKeyStorage) GetKeyCount(ctx context.Context) (count int, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		count, err = s.inner.GetKeyCount(ctx)
+		return err
+	})
+	return count, err
+}
+
+func (s *TimeoutKeyStorage) GetRandomKey(ctx context.Context, keyType KeyType) (key *KeyPair, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		key, err = s.inner.GetRandomKey(ctx, keyType)
+		return err
+	})
+	return key, err
+}
+
+func (s *TimeoutKeyStorage) HasKey(ctx context.Context) (hasKey bool, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		hasKey, err = s.inner.HasKey(ctx)
+		return err
+	})
+	return hasKey, err
+}
+
+// TimeoutChallengeStorage wraps a ChallengeStorage and bounds each of its
+// operations with a deadline.
+type TimeoutChallengeStorage struct {
+	inner   ChallengeStorage
+	timeout time.Duration
+}
+
+// NewTimeoutChallengeStorage wraps inner with a per-operation timeout, or
+// DefaultOperationTimeout if timeout is not positive.
+func NewTimeoutChallengeStorage(inner ChallengeStorage, timeout time.Duration) ChallengeStorage {
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return &TimeoutChallengeStorage{inner: inner, timeout: timeout}
+}
+
+func (s *TimeoutChallengeStorage) Save(ctx context.Context, ch *types.Challenge) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.Save(ctx, ch)
+	})
+}
+
+func (s *TimeoutChallengeStorage) Get(ctx context.Context, id string) (ch *types.Challenge, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		ch, err = s.inner.Get(ctx, id)
+		return err
+	})
+	return ch, err
+}
+
+func (s *TimeoutChallengeStorage) Delete(ctx context.Context, id string) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.Delete(ctx, id)
+	})
+}
+
+func (s *TimeoutChallengeStorage) Take(ctx context.Context, id string) (ch *types.Challenge, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		ch, err = s.inner.Take(ctx, id)
+		return err
+	})
+	return ch, err
+}
+
+func (s *TimeoutChallengeStorage) GetAll(ctx context.Context) (challenges []*types.Challenge, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		challenges, err = s.inner.GetAll(ctx)
+		return err
+	})
+	return challenges, err
+}
+
+func (s *TimeoutChallengeStorage) Redeem(ctx context.Context, id string, until time.Time) (redeemed bool, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		redeemed, err = s.inner.Redeem(ctx, id, until)
+		return err
+	})
+	return redeemed, err
+}
+
+func (s *TimeoutChallengeStorage) Close() error {
+	return s.inner.Close()
+}
+
+// TimeoutSecretStorage wraps a SecretStorage and bounds each of its
+// operations with a deadline.
+type TimeoutSecretStorage struct {
+	inner   SecretStorage
+	timeout time.Duration
+}
+
+// NewTimeoutSecretStorage wraps inner with a per-operation timeout, or
+// DefaultOperationTimeout if timeout is not positive.
+func NewTimeoutSecretStorage(inner SecretStorage, timeout time.Duration) SecretStorage {
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return &TimeoutSecretStorage{inner: inner, timeout: timeout}
+}
+
+func (s *TimeoutSecretStorage) SaveSecret(ctx context.Context, secret *SiteSecret) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.SaveSecret(ctx, secret)
+	})
+}
+
+func (s *TimeoutSecretStorage) GetSecret(ctx context.Context, hash string) (secret *SiteSecret, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		secret, err = s.inner.GetSecret(ctx, hash)
+		return err
+	})
+	return secret, err
+}
+
+func (s *TimeoutSecretStorage) DeleteSecret(ctx context.Context, hash string) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.DeleteSecret(ctx, hash)
+	})
+}
+
+func (s *TimeoutSecretStorage) GetAllSecrets(ctx context.Context) (secrets []*SiteSecret, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		secrets, err = s.inner.GetAllSecrets(ctx)
+		return err
+	})
+	return secrets, err
+}
+
+// TimeoutSiteStorage wraps a SiteStorage and bounds each of its operations
+// with a deadline.
+type TimeoutSiteStorage struct {
+	inner   SiteStorage
+	timeout time.Duration
+}
+
+// NewTimeoutSiteStorage wraps inner with a per-operation timeout, or
+// DefaultOperationTimeout if timeout is not positive.
+func NewTimeoutSiteStorage(inner SiteStorage, timeout time.Duration) SiteStorage {
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return &TimeoutSiteStorage{inner: inner, timeout: timeout}
+}
+
+func (s *TimeoutSiteStorage) SaveSite(ctx context.Context, site *Site) error {
+	return withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		return s.inner.SaveSite(ctx, site)
+	})
+}
+
+func (s *TimeoutSiteStorage) GetSite(ctx context.Context, key string) (site *Site, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		site, err = s.inner.GetSite(ctx, key)
+		return err
+	})
+	return site, err
+}
+
+func (s *TimeoutSiteStorage) GetAllSites(ctx context.Context) (sites []*Site, err error) {
+	err = withDeadline(ctx, s.timeout, func(ctx context.Context) error {
+		sites, err = s.inner.GetAllSites(ctx)
+		return err
+	})
+	return sites, err
+}
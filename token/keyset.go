@@ -0,0 +1,79 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// KeySet holds the public keys that pass tokens may be signed with, by key
+// ID. It is encoded as a JSON Web Key Set.
+type KeySet map[string]ed25519.PublicKey
+
+// jwk is an Ed25519 public key in JSON Web Key form (RFC 8037).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+}
+
+// Key returns the public key with the given ID. It is a KeyFunc.
+func (ks KeySet) Key(keyID string) (ed25519.PublicKey, error) {
+	key, ok := ks[keyID]
+	if !ok {
+		return nil, fmt.Errorf("no key with ID %q", keyID)
+	}
+	return key, nil
+}
+
+// MarshalJSON encodes the set as a JSON Web Key Set, ordered by key ID.
+func (ks KeySet) MarshalJSON() ([]byte, error) {
+	ids := make([]string, 0, len(ks))
+	for id := range ks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: make([]jwk, 0, len(ids))}
+	for _, id := range ids {
+		set.Keys = append(set.Keys, jwk{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(ks[id]),
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+		})
+	}
+	return json.Marshal(set)
+}
+
+// UnmarshalJSON decodes a JSON Web Key Set, skipping keys other than
+// Ed25519 public keys.
+func (ks *KeySet) UnmarshalJSON(data []byte) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	*ks = make(KeySet, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.KeyType != "OKP" || key.Curve != "Ed25519" {
+			continue
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid Ed25519 key %q", key.KeyID)
+		}
+		(*ks)[key.KeyID] = ed25519.PublicKey(x)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package token signs and verifies pass tokens: short-lived JWTs, signed
+// with Ed25519 (EdDSA), that prove a challenge was solved.
+//
+// uCaptcha issues a pass token on every successful verification. A service
+// that never talks to uCaptcha can check the token offline with Verify,
+// given the public keys published at GET /token/keys:
+//
+//	var keys token.KeySet
+//	if err := json.Unmarshal(jwks, &keys); err != nil {
+//		return err
+//	}
+//	claims, err := token.Verify(passToken, keys.Key, time.Now())
+//	if err != nil {
+//		return err // Forged, tampered with or expired
+//	}
+//	if claims.Action != "login" {
+//		return errors.New("token was issued for another action")
+//	}
+package token
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Issuer is the iss claim of pass tokens.
+const Issuer = "ucaptcha"
+
+// Errors returned by Verify, wrapped with details.
+var (
+	ErrMalformed  = errors.New("malformed token")
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrSignature  = errors.New("invalid token signature")
+	ErrExpired    = errors.New("token expired")
+)
+
+// Claims are the claims carried by a pass token.
+type Claims struct {
+	ChallengeID string `json:"jti"` // Challenge that was solved
+	Issuer      string `json:"iss"`
+	Site        string `json:"site,omitempty"`     // Key of the site the challenge was issued for
+	Hostname    string `json:"hostname,omitempty"` // Hostname the challenge was issued for
+	Action      string `json:"action,omitempty"`
+	IssuedAt    int64  `json:"iat"` // Unix seconds
+	ExpiresAt   int64  `json:"exp"` // Unix seconds
+}
+
+// Expired reports whether the token can no longer be used at now.
+func (c *Claims) Expired(now time.Time) bool {
+	return now.Unix() >= c.ExpiresAt
+}
+
+// header is the JOSE header of pass tokens.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// KeyFunc returns the public key with the given key ID.
+type KeyFunc func(keyID string) (ed25519.PublicKey, error)
+
+// Sign returns claims as a JWT signed with key, whose public key is looked
+// up under keyID.
+func Sign(claims Claims, keyID string, key ed25519.PrivateKey) (string, error) {
+	h, err := json.Marshal(header{Algorithm: "EdDSA", Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encode(h) + "." + encode(c)
+	return signingInput + "." + encode(ed25519.Sign(key, []byte(signingInput))), nil
+}
+
+// Verify checks the signature and expiry of a pass token and returns its
+// claims. The signing key is looked up with keyFunc.
+func Verify(token string, keyFunc KeyFunc, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrMalformed, len(parts))
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	// Only EdDSA is accepted, whatever the header asks for
+	if h.Algorithm != "EdDSA" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrMalformed, h.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+
+	key, err := keyFunc(h.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrUnknownKey, h.KeyID, err)
+	}
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	if claims.Issuer != Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrMalformed, claims.Issuer)
+	}
+	if claims.Expired(now) {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeJSON(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -3,14 +3,52 @@ package types
 import (
 	"math/big"
 	"time"
+
+	"github.com/ucaptcha/backend-go/classgroup"
+)
+
+// Supported puzzle types.
+const (
+	PuzzleVDF      = "vdf"      // Verifiable delay function, see the schemes below
+	PuzzleHashcash = "hashcash" // SHA-256 partial preimage
+	PuzzleArgon2id = "argon2id" // Memory-hard Argon2id output below a target
+)
+
+// Supported VDF schemes.
+const (
+	SchemeRSA        = "rsa"         // Sequential squaring modulo an RSA modulus
+	SchemeClassGroup = "class_group" // Sequential squaring in an imaginary quadratic class group
 )
 
 // Challenge represents the data associated with a cryptographic challenge.
 type Challenge struct {
 	ID        string
-	G         *big.Int
-	N         *big.Int // N is still needed for generating g, but P/Q are not
-	T         int64    // Difficulty (number of iterations)
+	Type      string           // Puzzle type, one of the Puzzle* constants
+	Scheme    string           // VDF scheme, SchemeRSA or SchemeClassGroup
+	G         *big.Int         // Input of the RSA VDF
+	Form      *classgroup.Form // Input of the class group VDF
+	N         *big.Int         // RSA modulus, or the discriminant for class group challenges
+	Prefix    []byte           // Random prefix of hash puzzles, used as the salt for Argon2id
+	Target    *big.Int         // Exclusive upper bound on the Argon2id output
+	Argon2    *Argon2Params    // Cost parameters of Argon2id puzzles
+	T         int64            // Difficulty (number of iterations, or leading zero bits for hash puzzles)
 	CreatedAt time.Time
-	KeyID     string // Reference to the key used for this challenge
+	ExpiresAt time.Time // Zero for challenges stored before expiry was recorded
+	KeyID     string    // Reference to the key used for this challenge
+	Site      string    // Key of the site the challenge was issued for, carried into pass tokens
+	Hostname  string    // Hostname the challenge was issued for, carried into pass tokens
+	Action    string    // Action the challenge protects, carried into pass tokens
+}
+
+// Expired reports whether the challenge can no longer be solved at now.
+func (c *Challenge) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
+}
+
+// Argon2Params holds the Argon2id cost parameters of a memory-hard puzzle.
+type Argon2Params struct {
+	Memory  uint32 // Memory cost in KiB
+	Time    uint32 // Number of passes over the memory
+	Threads uint8  // Degree of parallelism
+	KeyLen  uint32 // Output length in bytes
 }